@@ -0,0 +1,227 @@
+package dbexplorer
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Настройки произвольных запросов через /_sql
+type SQLConfig struct {
+	// Роли, которым разрешен /_sql. Пусто - эндпоинт выключен
+	Roles []string `yaml:"roles"`
+	// Таймаут запроса
+	Timeout time.Duration `yaml:"timeout"`
+	// Сколько строк отдаем максимум. Остальные отбрасываем и ставим truncated
+	MaxRows int `yaml:"max_rows"`
+}
+
+// Как часто сбрасываем ответ клиенту при потоковой отдаче, в строках
+const flushEvery = 100
+
+// Описание столбца результата для клиента
+type ColumnInfo struct {
+	Name         string `json:"name"`
+	DatabaseType string `json:"database_type"`
+	Nullable     *bool  `json:"nullable,omitempty"`
+	Length       *int64 `json:"length,omitempty"`
+	Precision    *int64 `json:"precision,omitempty"`
+	Scale        *int64 `json:"scale,omitempty"`
+}
+
+func columnInfo(columns []*sql.ColumnType) []ColumnInfo {
+
+	info := make([]ColumnInfo, len(columns))
+
+	for i, column := range columns {
+
+		info[i] = ColumnInfo{Name: column.Name(), DatabaseType: column.DatabaseTypeName()}
+
+		if nullable, ok := column.Nullable(); ok {
+			info[i].Nullable = &nullable
+		}
+
+		if length, ok := column.Length(); ok {
+			info[i].Length = &length
+		}
+
+		if precision, scale, ok := column.DecimalSize(); ok {
+			info[i].Precision, info[i].Scale = &precision, &scale
+		}
+	}
+
+	return info
+}
+
+// Хендлер для произвольного запроса на чтение. Тело: {"query": "SELECT ...", "args": [...]}.
+// Вызывается по эндпоинту "/_sql" [POST]
+func (h *Handler) AdHocSQL(w http.ResponseWriter, r *http.Request) {
+
+	if len(h.Config.SQL.Roles) == 0 {
+		writeError(w, r, http.StatusNotFound, CodeNotFound, "ad-hoc SQL is disabled")
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed,
+			fmt.Sprintf("method %s is not allowed", r.Method))
+		return
+	}
+
+	if !h.authorize(w, r, h.Config.SQL.Roles) {
+		return
+	}
+
+	var body struct {
+		Query string        `json:"query"`
+		Args  []interface{} `json:"args"`
+	}
+
+	err := json.NewDecoder(r.Body).Decode(&body)
+
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, CodeInvalidBody, "request body must be a JSON object")
+		return
+	}
+
+	err = checkReadOnlySQL(body.Query)
+
+	if err != nil {
+		requestLogger(r).Warn("ad-hoc query rejected", "principal", h.principal(r), "error", err)
+		writeProblem(w, r, FieldProblem(http.StatusBadRequest, CodeNotReadOnly, "query", err.Error()))
+		return
+	}
+
+	requestLogger(r).Info("ad-hoc query", "principal", h.principal(r), "query", body.Query)
+
+	ctx, cancel := context.WithTimeout(r.Context(), h.Config.SQL.Timeout)
+	defer cancel()
+
+	// вторая линия защиты после проверки текста: MySQL сам не даст ничего изменить
+	tx, err := h.DB.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+
+	if err != nil {
+		h.dbError(ctx, w, r, err, "bad begin of read-only transaction")
+		return
+	}
+
+	defer tx.Rollback() //nolint:errcheck
+
+	rows, err := h.queryOn(ctx, tx, "", "sql", body.Query, body.Args...)
+
+	if err != nil {
+		h.dbError(ctx, w, r, err, "bad ad-hoc query")
+		return
+	}
+
+	defer rows.Close()
+
+	columns, err := rows.ColumnTypes()
+
+	if err != nil {
+		h.dbError(ctx, w, r, err, "bad column types")
+		return
+	}
+
+	h.streamRows(ctx, cancel, w, r, columns, rows)
+}
+
+// Отдаем строки по мере чтения: {"response": {"columns": [...], "rows": [[...], ...],
+// "truncated": false}}. Статус уже отправлен, поэтому ошибку в середине отдаем
+// полем "error" в конце ответа
+func (h *Handler) streamRows(ctx context.Context, cancel context.CancelFunc, w http.ResponseWriter,
+	r *http.Request, columns []*sql.ColumnType, rows *sql.Rows) {
+
+	meta, err := json.Marshal(columnInfo(columns))
+
+	if err != nil {
+		requestLogger(r).Error("bad packed json", "error", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	flusher, _ := w.(http.Flusher)
+
+	fmt.Fprintf(w, `{"response":{"columns":%s,"rows":[`, meta)
+
+	values := make([]interface{}, len(columns))
+	dest := make([]interface{}, len(columns))
+
+	for i := range values {
+		dest[i] = &values[i]
+	}
+
+	count := 0
+	truncated := false
+
+	for rows.Next() {
+
+		if count == h.Config.SQL.MaxRows {
+			truncated = true
+			// иначе драйвер дочитает весь результат при закрытии
+			cancel()
+			break
+		}
+
+		err = rows.Scan(dest...)
+
+		if err != nil {
+			break
+		}
+
+		record := make([]interface{}, len(columns))
+
+		for i, column := range columns {
+			record[i] = convertValue(column.DatabaseTypeName(), values[i])
+		}
+
+		line, errMarshal := json.Marshal(record)
+
+		if errMarshal != nil {
+			err = errMarshal
+			break
+		}
+
+		if count > 0 {
+			w.Write([]byte{','}) //nolint:errcheck
+		}
+
+		_, err = w.Write(line)
+
+		if err != nil {
+			requestLogger(r).Warn("bad write of response", "error", err)
+			return
+		}
+
+		count++
+
+		if flusher != nil && count%flushEvery == 0 {
+			flusher.Flush()
+		}
+	}
+
+	if err == nil && !truncated {
+		err = rows.Err()
+	}
+
+	h.rowsReturned(ctx, "", "sql", count)
+
+	fmt.Fprintf(w, `],"truncated":%t}`, truncated)
+
+	if err != nil {
+
+		requestLogger(r).Error("ad-hoc query failed while streaming", "rows", count, "error", err)
+
+		problem, _ := json.Marshal(streamProblem(ctx, r))
+		fmt.Fprintf(w, `,"error":%s`, problem)
+	}
+
+	w.Write([]byte{'}'}) //nolint:errcheck
+}
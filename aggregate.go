@@ -0,0 +1,335 @@
+package dbexplorer
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Агрегатные функции /{table}/_aggregate в порядке столбцов ответа
+var aggregateFuncs = []string{"count", "sum", "avg", "min", "max"}
+
+// Параметры агрегации, которые не являются фильтрами по столбцам
+var aggregateParams = map[string]bool{
+	"group_by": true,
+	"count":    true,
+	"sum":      true,
+	"avg":      true,
+	"min":      true,
+	"max":      true,
+	"having":   true,
+	"limit":    true,
+	"offset":   true,
+	"format":   true,
+	"null":     true,
+}
+
+// Порог вида count>10 или sum_amount<=100.5
+var havingRe = regexp.MustCompile(`^(\w+)\s*(>=|<=|!=|<>|=|>|<)\s*(-?[0-9]+(?:\.[0-9]+)?)$`)
+
+// Запрос агрегации, собранный из параметров
+type aggregateQuery struct {
+	// Выражения SELECT
+	Columns []string
+	// Столбцы GROUP BY
+	GroupBy []string
+	// Условия HAVING и их аргументы
+	Having     []string
+	HavingArgs []interface{}
+}
+
+// Значения параметра: ?group_by=a,b и ?group_by=a&group_by=b - одно и то же
+func listValues(query url.Values, name string) []string {
+
+	values := make([]string, 0)
+
+	for _, value := range query[name] {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				values = append(values, item)
+			}
+		}
+	}
+
+	return values
+}
+
+// Тип столбца без размеров и атрибутов: int(11) unsigned -> int
+func baseType(columnType string) string {
+
+	base := strings.ToLower(columnType)
+
+	if i := strings.IndexAny(base, "( "); i >= 0 {
+		base = base[:i]
+	}
+
+	return base
+}
+
+// Целый ли тип столбца: tinyint(1), bigint unsigned
+func isIntType(columnType string) bool {
+
+	switch baseType(columnType) {
+	case "tinyint", "smallint", "mediumint", "int", "integer", "bigint":
+		return true
+	}
+
+	return false
+}
+
+// Числовой ли тип столбца: int(11), decimal(10,2), double unsigned
+func isNumericType(columnType string) bool {
+
+	switch baseType(columnType) {
+	case "tinyint", "smallint", "mediumint", "int", "integer", "bigint",
+		"decimal", "numeric", "float", "double", "real":
+		return true
+	}
+
+	return false
+}
+
+// Собираем SELECT, GROUP BY и HAVING из параметров. Без функций считаем count=*
+func buildAggregate(table TableInfo, query url.Values) (*aggregateQuery, error) {
+
+	agg := &aggregateQuery{}
+	groups := make(map[string]bool)
+	// имена столбцов с результатами функций, на них ссылается having
+	aliases := make(map[string]bool)
+
+	for _, name := range listValues(query, "group_by") {
+
+		field, ok := fieldByName(table, name)
+
+		if !ok {
+			return nil, FieldProblem(http.StatusBadRequest, CodeUnknownField, "group_by",
+				fmt.Sprintf("can not group by %s: no such field", name))
+		}
+
+		if groups[name] {
+			continue
+		}
+
+		groups[name] = true
+		agg.GroupBy = append(agg.GroupBy, field.Column())
+		agg.Columns = append(agg.Columns, fmt.Sprintf("%s AS %s", field.Column(), quoteIdent(name)))
+	}
+
+	functions := 0
+
+	for _, function := range aggregateFuncs {
+
+		for _, name := range listValues(query, function) {
+
+			functions++
+
+			if function == "count" && name == "*" {
+				if !aliases["count"] {
+					aliases["count"] = true
+					agg.Columns = append(agg.Columns, "COUNT(*) AS `count`")
+				}
+				continue
+			}
+
+			field, ok := fieldByName(table, name)
+
+			if !ok {
+				return nil, FieldProblem(http.StatusBadRequest, CodeUnknownField, function,
+					fmt.Sprintf("can not %s %s: no such field", function, name))
+			}
+
+			if (function == "sum" || function == "avg") && !isNumericType(field.ColumnType) {
+				return nil, FieldProblem(http.StatusBadRequest, CodeInvalidType, function,
+					fmt.Sprintf("can not %s %s: field is %s, not a number", function, name, field.ColumnType))
+			}
+
+			alias := function + "_" + name
+
+			if aliases[alias] {
+				continue
+			}
+
+			aliases[alias] = true
+			agg.Columns = append(agg.Columns, fmt.Sprintf("%s(%s) AS %s",
+				strings.ToUpper(function), field.Column(), quoteIdent(alias)))
+		}
+	}
+
+	if functions == 0 {
+		aliases["count"] = true
+		agg.Columns = append(agg.Columns, "COUNT(*) AS `count`")
+	}
+
+	for _, threshold := range query["having"] {
+
+		match := havingRe.FindStringSubmatch(strings.TrimSpace(threshold))
+
+		if match == nil {
+			return nil, FieldProblem(http.StatusBadRequest, CodeInvalidParam, "having",
+				fmt.Sprintf("threshold %q must look like count>10", threshold))
+		}
+
+		if !aliases[match[1]] {
+			return nil, FieldProblem(http.StatusBadRequest, CodeInvalidParam, "having",
+				fmt.Sprintf("threshold %q refers to unknown aggregate %s", threshold, match[1]))
+		}
+
+		value, _ := strconv.ParseFloat(match[3], 64)
+
+		agg.Having = append(agg.Having, fmt.Sprintf("%s %s ?", quoteIdent(match[1]), match[2]))
+		agg.HavingArgs = append(agg.HavingArgs, value)
+	}
+
+	return agg, nil
+}
+
+// Хендлер для агрегатов по таблице: ?group_by=status&count=*&sum=amount&having=count>10.
+// Группы отдаются страницами по limit и offset, всего групп - в X-Total-Count.
+// Остальные параметры - фильтры, как у списка. Вызывается по эндпоинту "/{table}/_aggregate" [GET]
+func (h *Handler) Aggregate(w http.ResponseWriter, r *http.Request) {
+
+	table := strings.Split(r.URL.Path, "/")[1]
+
+	tables := h.tables()
+
+	cond, idx, _ := contains(tables, table)
+
+	if !cond {
+		writeError(w, r, http.StatusNotFound, CodeUnknownTable, fmt.Sprintf("unknown table %s", table))
+		return
+	}
+
+	lim, off, err := h.pageParams(table, r)
+
+	if err != nil {
+		writeProblem(w, r, pageProblem(err))
+		return
+	}
+
+	agg, err := buildAggregate(tables[idx], r.URL.Query())
+
+	if err != nil {
+		writeProblem(w, r, err.(*Problem))
+		return
+	}
+
+	where, args, err := filterParams(tables[idx], r, aggregateParams, h.Config.Limits.StrictFilters)
+
+	if err != nil {
+		writeProblem(w, r, err.(*Problem))
+		return
+	}
+
+	format, err := streamFormat(r)
+
+	if err != nil {
+		writeProblem(w, r, err.(*Problem))
+		return
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM %s", strings.Join(agg.Columns, ", "), tables[idx].Table())
+
+	if where != "" {
+		query += " WHERE " + where
+	}
+
+	if len(agg.GroupBy) > 0 {
+		query += " GROUP BY " + strings.Join(agg.GroupBy, ", ")
+	}
+
+	if len(agg.Having) > 0 {
+		query += " HAVING " + strings.Join(agg.Having, " AND ")
+		args = append(args, agg.HavingArgs...)
+	}
+
+	// запрос без LIMIT нужен для подсчета групп
+	grouped := query
+
+	if len(agg.GroupBy) > 0 {
+		query += fmt.Sprintf(" ORDER BY %s LIMIT %d, %d", strings.Join(agg.GroupBy, ", "), off, lim)
+	}
+
+	ctx, cancel := h.operationContext(r.Context(), "aggregate")
+	defer cancel()
+
+	err = h.checkQueryCost(ctx, table, query, args...)
+
+	if err == ErrFullScan {
+		writeError(w, r, http.StatusBadRequest, CodeFullScan, err.Error())
+		return
+	}
+
+	if err != nil && h.contextError(ctx, w, r, err) {
+		return
+	}
+
+	if err != nil {
+		requestLogger(r).Warn("bad explain of query", "table", table, "error", err)
+	}
+
+	if len(agg.GroupBy) > 0 {
+
+		// limit обрезает группы, поэтому их общее число отдаем в заголовке, как у страниц
+		var total int64
+
+		err = h.queryRow(ctx, table, "aggregate", []interface{}{&total},
+			fmt.Sprintf("SELECT COUNT(*) FROM (%s) AS `groups`", grouped), args...)
+
+		if err != nil {
+			h.dbError(ctx, w, r, err, "bad count of aggregate groups", "table", table)
+			return
+		}
+
+		w.Header().Set("X-Total-Count", strconv.FormatInt(total, 10))
+		w.Header().Set("X-Limit", strconv.Itoa(lim))
+		w.Header().Set("X-Offset", strconv.Itoa(off))
+	}
+
+	rows, err := h.query(ctx, table, "aggregate", query, args...)
+
+	if err != nil {
+		h.dbError(ctx, w, r, err, "bad aggregate query", "table", table)
+		return
+	}
+
+	defer rows.Close()
+
+	names, err := rows.Columns()
+
+	if err != nil {
+		h.dbError(ctx, w, r, err, "bad aggregate query", "table", table)
+		return
+	}
+
+	stream := newRecordStream(w, format, names, h.exportNull(r))
+	written := true
+
+	err = scanEach(rows, func(record map[string]interface{}) error {
+
+		if errWrite := stream.Write(record); errWrite != nil {
+			written = false
+			return errWrite
+		}
+
+		return nil
+	})
+
+	h.rowsReturned(r.Context(), table, "aggregate", stream.count)
+
+	if !written {
+		requestLogger(r).Warn("bad write of response", "table", table, "records", stream.count, "error", err)
+		return
+	}
+
+	if stream.Close(ctx, r, err) {
+		if err != nil {
+			requestLogger(r).Error("aggregate failed while streaming", "table", table, "records", stream.count, "error", err)
+		}
+		return
+	}
+
+	h.dbError(ctx, w, r, err, "bad aggregate query", "table", table)
+}
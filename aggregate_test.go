@@ -0,0 +1,76 @@
+package dbexplorer
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestBuildAggregate(t *testing.T) {
+
+	table := TableInfo{Name: "orders", ID: "id", Fields: []FieldInfo{
+		{Name: "id", ColumnType: TypeInt, IsKey: true},
+		{Name: "status", DBName: "st", ColumnType: TypeVarchar},
+		{Name: "amount", ColumnType: "decimal(10,2)"},
+	}}
+
+	query, _ := url.ParseQuery("group_by=status&count=*&sum=amount,amount&max=status&having=count>=2&having=sum_amount<10.5")
+
+	agg, err := buildAggregate(table, query)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &aggregateQuery{
+		Columns:    []string{"st AS `status`", "COUNT(*) AS `count`", "SUM(amount) AS `sum_amount`", "MAX(st) AS `max_status`"},
+		GroupBy:    []string{"st"},
+		Having:     []string{"`count` >= ?", "`sum_amount` < ?"},
+		HavingArgs: []interface{}{2.0, 10.5},
+	}
+
+	if !reflect.DeepEqual(agg, want) {
+		t.Errorf("got %+v, want %+v", agg, want)
+	}
+
+	agg, err = buildAggregate(table, url.Values{})
+
+	if err != nil || !reflect.DeepEqual(agg.Columns, []string{"COUNT(*) AS `count`"}) {
+		t.Errorf("default aggregate: got %+v, %v", agg, err)
+	}
+
+	cases := []struct {
+		query string
+		code  string
+		field string
+	}{
+		{"group_by=password", CodeUnknownField, "group_by"},
+		{"sum=status", CodeInvalidType, "sum"},
+		{"avg=nope", CodeUnknownField, "avg"},
+		{"count=*&having=count>x", CodeInvalidParam, "having"},
+		{"count=*&having=status>1", CodeInvalidParam, "having"},
+	}
+
+	for _, c := range cases {
+
+		query, _ := url.ParseQuery(c.query)
+
+		_, err := buildAggregate(table, query)
+
+		if p, ok := err.(*Problem); !ok || p.Code != c.code || p.Field != c.field {
+			t.Errorf("%s: got %v, want %s on %s", c.query, err, c.code, c.field)
+		}
+	}
+}
+
+func TestIsNumericType(t *testing.T) {
+
+	for columnType, want := range map[string]bool{
+		"int": true, "int(11) unsigned": true, "decimal(10,2)": true, "DOUBLE": true,
+		"varchar(255)": false, "text": false, "datetime": false,
+	} {
+		if got := isNumericType(columnType); got != want {
+			t.Errorf("%s: got %t", columnType, got)
+		}
+	}
+}
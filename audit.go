@@ -0,0 +1,665 @@
+package dbexplorer
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	AuditSinkStdout = "stdout"
+	AuditSinkFile   = "file"
+	AuditSinkDB     = "db"
+	AuditSinkNone   = "none"
+)
+
+const (
+	AuditCreate = "create"
+	AuditUpdate = "update"
+	AuditDelete = "delete"
+	AuditImport = "import"
+	AuditCall   = "call"
+)
+
+// Запись журнала аудита: кто, что и когда поменял
+type AuditEntry struct {
+	Time      time.Time              `json:"time"`
+	RequestID string                 `json:"request_id"`
+	Principal string                 `json:"principal"`
+	Table     string                 `json:"table"`
+	Key       string                 `json:"key"`
+	Operation string                 `json:"operation"`
+	Before    map[string]interface{} `json:"before"`
+	After     map[string]interface{} `json:"after"`
+}
+
+// Условия выборки записей журнала. Пустые поля не фильтруют
+type AuditFilter struct {
+	Table     string
+	Key       string
+	Operation string
+	Principal string
+	Since     time.Time
+	Until     time.Time
+	Limit     int
+	Offset    int
+}
+
+// Приемник журнала аудита
+type AuditSink interface {
+	Write(ctx context.Context, entry AuditEntry) error
+	// Записи в порядке от новых к старым
+	Query(ctx context.Context, filter AuditFilter) ([]AuditEntry, error)
+}
+
+// Приемник не умеет читать записи обратно
+var ErrAuditNotQueryable = fmt.Errorf("audit sink is write-only")
+
+// Создаем приемник журнала по настройкам
+func NewAuditSink(db *sql.DB, cfg AuditConfig) (AuditSink, error) {
+
+	switch cfg.Sink {
+	case AuditSinkStdout:
+		return &WriterAuditSink{Out: os.Stdout}, nil
+	case AuditSinkFile:
+		return &FileAuditSink{Path: cfg.File}, nil
+	case AuditSinkDB:
+		sink := &DBAuditSink{DB: db, Table: cfg.Table}
+		return sink, sink.CreateTable()
+	case AuditSinkNone:
+		return nil, nil
+	}
+
+	return nil, fmt.Errorf("unknown audit sink %q", cfg.Sink)
+}
+
+// Пишем журнал JSON lines в произвольный поток, например в stdout
+type WriterAuditSink struct {
+	Out io.Writer
+	mu  sync.Mutex
+}
+
+func (s *WriterAuditSink) Write(_ context.Context, entry AuditEntry) error {
+
+	line, err := json.Marshal(entry)
+
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err = s.Out.Write(append(line, '\n'))
+
+	return err
+}
+
+func (s *WriterAuditSink) Query(context.Context, AuditFilter) ([]AuditEntry, error) {
+	return nil, ErrAuditNotQueryable
+}
+
+// Пишем журнал JSON lines в файл. Файл открывается на дозапись при каждой записи,
+// поэтому его можно ротировать внешними средствами
+type FileAuditSink struct {
+	Path string
+	mu   sync.Mutex
+}
+
+func (s *FileAuditSink) Write(_ context.Context, entry AuditEntry) error {
+
+	line, err := json.Marshal(entry)
+
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file, err := os.OpenFile(s.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o640)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(append(line, '\n'))
+
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// Файл читаем потоком в два прохода: первый считает подходящие записи, второй берет
+// нужную страницу. В памяти не больше Limit записей при любом размере файла
+func (s *FileAuditSink) Query(_ context.Context, filter AuditFilter) ([]AuditEntry, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	total := 0
+
+	err := s.scan(filter, func(AuditEntry) { total++ })
+
+	if err != nil {
+		return nil, err
+	}
+
+	// в файле записи идут от старых к новым, страница от новых -
+	// это записи с номерами [end-limit, end)
+	end := total - filter.Offset
+
+	if end <= 0 {
+		return make([]AuditEntry, 0), nil
+	}
+
+	start := 0
+
+	if filter.Limit > 0 && end > filter.Limit {
+		start = end - filter.Limit
+	}
+
+	page := make([]AuditEntry, 0, end-start)
+	n := 0
+
+	err = s.scan(filter, func(entry AuditEntry) {
+		if n >= start && n < end {
+			page = append(page, entry)
+		}
+		n++
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	for i, j := 0, len(page)-1; i < j; i, j = i+1, j-1 {
+		page[i], page[j] = page[j], page[i]
+	}
+
+	return page, nil
+}
+
+// Проходим по записям файла, подходящим под фильтр. Нет файла - нет записей
+func (s *FileAuditSink) scan(filter AuditFilter, fn func(AuditEntry)) error {
+
+	file, err := os.Open(s.Path)
+
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	for scanner.Scan() {
+
+		entry := AuditEntry{}
+
+		err = json.Unmarshal(scanner.Bytes(), &entry)
+
+		if err != nil {
+			return err
+		}
+
+		if filter.match(entry) {
+			fn(entry)
+		}
+	}
+
+	return scanner.Err()
+}
+
+func (f AuditFilter) match(entry AuditEntry) bool {
+
+	if f.Table != "" && f.Table != entry.Table {
+		return false
+	}
+
+	if f.Key != "" && f.Key != entry.Key {
+		return false
+	}
+
+	if f.Operation != "" && f.Operation != entry.Operation {
+		return false
+	}
+
+	if f.Principal != "" && f.Principal != entry.Principal {
+		return false
+	}
+
+	if !f.Since.IsZero() && entry.Time.Before(f.Since) {
+		return false
+	}
+
+	if !f.Until.IsZero() && entry.Time.After(f.Until) {
+		return false
+	}
+
+	return true
+}
+
+// Пишем журнал в таблицу той же базы
+type DBAuditSink struct {
+	DB    *sql.DB
+	Table string
+}
+
+func (s *DBAuditSink) CreateTable() error {
+
+	_, err := s.DB.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+  id bigint NOT NULL AUTO_INCREMENT,
+  created_at datetime(6) NOT NULL,
+  request_id varchar(64) NOT NULL,
+  principal varchar(255) NOT NULL,
+  table_name varchar(255) NOT NULL,
+  record_key varchar(255) NOT NULL,
+  operation varchar(16) NOT NULL,
+  before_image json DEFAULT NULL,
+  after_image json DEFAULT NULL,
+  PRIMARY KEY (id),
+  KEY table_key (table_name, record_key)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`, s.Table))
+
+	return err
+}
+
+func (s *DBAuditSink) Write(ctx context.Context, entry AuditEntry) error {
+	return s.writeOn(ctx, s.DB, entry)
+}
+
+// Пишем запись через db: через транзакцию изменения она фиксируется вместе с ним
+func (s *DBAuditSink) writeOn(ctx context.Context, db querier, entry AuditEntry) error {
+
+	before, err := marshalImage(entry.Before)
+
+	if err != nil {
+		return err
+	}
+
+	after, err := marshalImage(entry.After)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = db.ExecContext(ctx,
+		fmt.Sprintf(`INSERT INTO %s (created_at, request_id, principal, table_name,
+  record_key, operation, before_image, after_image) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`, s.Table),
+		entry.Time.UTC().Format("2006-01-02 15:04:05.999999"), entry.RequestID, entry.Principal,
+		entry.Table, entry.Key, entry.Operation, before, after,
+	)
+
+	return err
+}
+
+func (s *DBAuditSink) Query(ctx context.Context, filter AuditFilter) ([]AuditEntry, error) {
+
+	where := make([]string, 0)
+	args := make([]interface{}, 0)
+
+	conds := []struct {
+		column string
+		value  string
+	}{
+		{"table_name", filter.Table},
+		{"record_key", filter.Key},
+		{"operation", filter.Operation},
+		{"principal", filter.Principal},
+	}
+
+	for _, cond := range conds {
+		if cond.value != "" {
+			where = append(where, cond.column+" = ?")
+			args = append(args, cond.value)
+		}
+	}
+
+	if !filter.Since.IsZero() {
+		where = append(where, "created_at >= ?")
+		args = append(args, filter.Since.UTC().Format("2006-01-02 15:04:05.999999"))
+	}
+
+	if !filter.Until.IsZero() {
+		where = append(where, "created_at <= ?")
+		args = append(args, filter.Until.UTC().Format("2006-01-02 15:04:05.999999"))
+	}
+
+	query := fmt.Sprintf(`SELECT created_at, request_id, principal, table_name, record_key,
+  operation, before_image, after_image FROM %s`, s.Table)
+
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+
+	query += " ORDER BY id DESC LIMIT ?, ?"
+	args = append(args, filter.Offset, filter.Limit)
+
+	rows, err := s.DB.QueryContext(ctx, query, args...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	entries := make([]AuditEntry, 0)
+
+	for rows.Next() {
+
+		var created string
+		var before, after sql.NullString
+
+		entry := AuditEntry{}
+
+		err = rows.Scan(&created, &entry.RequestID, &entry.Principal, &entry.Table,
+			&entry.Key, &entry.Operation, &before, &after)
+
+		if err != nil {
+			return nil, err
+		}
+
+		entry.Time, err = parseAuditTime(created)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if entry.Before, err = unmarshalImage(before); err != nil {
+			return nil, err
+		}
+
+		if entry.After, err = unmarshalImage(after); err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// created_at приходит строкой, если в DSN нет parseTime, и RFC3339 если есть
+func parseAuditTime(value string) (time.Time, error) {
+
+	t, err := time.Parse("2006-01-02 15:04:05.999999", value)
+
+	if err == nil {
+		return t, nil
+	}
+
+	return time.Parse(time.RFC3339Nano, value)
+}
+
+func marshalImage(image map[string]interface{}) (interface{}, error) {
+
+	if image == nil {
+		return nil, nil
+	}
+
+	data, err := json.Marshal(image)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return string(data), nil
+}
+
+func unmarshalImage(value sql.NullString) (map[string]interface{}, error) {
+
+	if !value.Valid {
+		return nil, nil
+	}
+
+	image := make(map[string]interface{})
+
+	return image, json.Unmarshal([]byte(value.String), &image)
+}
+
+// Кто выполняет запрос: отпечаток известного API-ключа или адрес клиента.
+// Заголовкам вроде X-Principal не верим: их может подставить кто угодно
+func (h *Handler) principal(r *http.Request) string {
+
+	if key := h.apiKey(r); key != "" {
+		return keyFingerprint(key)
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// Изменяющий запрос вместе с записью в журнал аудита. Чтение до, сам запрос, чтение после
+// и запись в журнал идут одной транзакцией, а SELECT ... FOR UPDATE держит запись, чтобы
+// параллельный запрос не вклинился между ними. Журнал в БД пишется в этой же транзакции,
+// в файл и stdout - перед фиксацией: изменение без записи в журнале не фиксируется.
+// id = 0 у INSERT: ключ берем из LastInsertId. У таблицы без ключа образов нет.
+// Без аудита - обычный запрос без чтений
+func (h *Handler) execAudited(ctx context.Context, r *http.Request, table TableInfo, operation string,
+	id int64, query string, args ...interface{}) (sql.Result, error) {
+
+	if h.Audit == nil {
+		return h.exec(ctx, table.Name, operation, query, args...)
+	}
+
+	var res sql.Result
+
+	// транзакцию целиком можно повторить при дедлоке
+	err := h.retry(ctx, table.Name, operation, func() error {
+
+		tx, err := h.DB.BeginTx(ctx, nil)
+
+		if err != nil {
+			return err
+		}
+
+		defer tx.Rollback() //nolint:errcheck
+
+		var before, after map[string]interface{}
+
+		if id != 0 && table.ID != "" {
+			if before, err = h.fetchRecordOn(ctx, tx, table, id, true); err != nil {
+				return err
+			}
+		}
+
+		res, err = h.execOn(ctx, tx, table.Name, operation, query, args...)
+
+		if err != nil {
+			return err
+		}
+
+		key := id
+
+		if key == 0 {
+			if key, err = res.LastInsertId(); err != nil {
+				return err
+			}
+		}
+
+		if operation != AuditDelete && key != 0 && table.ID != "" {
+			if after, err = h.fetchRecordOn(ctx, tx, table, key, false); err != nil {
+				return err
+			}
+		}
+
+		// записи с таким ключом не было: менять и записывать в журнал нечего
+		if operation != AuditCreate && before == nil {
+			return tx.Commit()
+		}
+
+		entry := h.auditEntry(r, AuditEntry{
+			Table:     table.Name,
+			Operation: operation,
+			Before:    before,
+			After:     after,
+		})
+
+		if key != 0 {
+			entry.Key = strconv.FormatInt(key, 10)
+		}
+
+		if sink, ok := h.Audit.(*DBAuditSink); ok {
+			err = sink.writeOn(ctx, tx, entry)
+		} else {
+			err = h.Audit.Write(ctx, entry)
+		}
+
+		if err != nil {
+			return fmt.Errorf("audit: %v", err)
+		}
+
+		return tx.Commit()
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if affected, errAffected := res.RowsAffected(); errAffected == nil && h.Metrics != nil {
+		h.Metrics.RowsAffected.Add(float64(affected), table.Name, operation)
+	}
+
+	return res, nil
+}
+
+// Дописываем в запись время, запрос и того, кто его выполняет
+func (h *Handler) auditEntry(r *http.Request, entry AuditEntry) AuditEntry {
+
+	entry.Time = time.Now().UTC()
+	entry.RequestID = RequestIDFromContext(r.Context())
+	entry.Principal = h.principal(r)
+
+	return entry
+}
+
+// Пишем в журнал запись о том, что не укладывается в одну транзакцию: импорт, вызов
+// процедуры. Изменение уже выполнено, поэтому запись не отменяем вместе с запросом,
+// а ошибку записи отдаем вызывающему: ответить успехом без записи в журнале нельзя
+func (h *Handler) writeAudit(r *http.Request, entry AuditEntry) error {
+
+	if h.Audit == nil {
+		return nil
+	}
+
+	entry = h.auditEntry(r, entry)
+
+	err := h.Audit.Write(context.WithoutCancel(r.Context()), entry)
+
+	if err != nil {
+		requestLogger(r).Error("bad write of audit entry", "table", entry.Table, "key", entry.Key,
+			"operation", entry.Operation, "error", err)
+	}
+
+	return err
+}
+
+// Хендлер для чтения журнала аудита. В записях полные образы строк, поэтому
+// читать журнал могут только роли из audit.roles. Вызывается по эндпоинту "/_audit?table=a&key=b&operation=c&principal=d&since=e&until=f&limit=g&offset=h" [GET]
+func (h *Handler) AuditLog(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed,
+			fmt.Sprintf("method %s is not allowed", r.Method))
+		return
+	}
+
+	if h.Audit == nil {
+		writeError(w, r, http.StatusNotFound, CodeNotFound, "audit is disabled")
+		return
+	}
+
+	if len(h.Config.Audit.Roles) == 0 {
+		writeError(w, r, http.StatusNotFound, CodeNotFound, "reading the audit log is disabled")
+		return
+	}
+
+	if !h.authorize(w, r, h.Config.Audit.Roles) {
+		return
+	}
+
+	query := r.URL.Query()
+
+	filter := AuditFilter{
+		Table:     query.Get("table"),
+		Key:       query.Get("key"),
+		Operation: query.Get("operation"),
+		Principal: query.Get("principal"),
+		Limit:     50,
+	}
+
+	if lim, err := strconv.Atoi(query.Get("limit")); err == nil && lim > 0 {
+		filter.Limit = min(lim, h.Config.Limits.MaxLimit)
+	}
+
+	if off, err := strconv.Atoi(query.Get("offset")); err == nil && off > 0 {
+		filter.Offset = off
+	}
+
+	for name, dst := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+
+		value := query.Get(name)
+
+		if value == "" {
+			continue
+		}
+
+		t, err := time.Parse(time.RFC3339, value)
+
+		if err != nil {
+			writeProblem(w, r, FieldProblem(http.StatusBadRequest, CodeInvalidParam, name,
+				fmt.Sprintf("%s must be RFC3339 time", name)))
+			return
+		}
+
+		*dst = t
+	}
+
+	entries, err := h.Audit.Query(r.Context(), filter)
+
+	if err == ErrAuditNotQueryable {
+		writeError(w, r, http.StatusNotImplemented, CodeNotImplemented, err.Error())
+		return
+	}
+
+	if err != nil && h.contextError(r.Context(), w, r, err) {
+		return
+	}
+
+	if err != nil {
+		requestLogger(r).Error("bad query of audit log", "error", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"response": map[string]interface{}{
+			"entries": entries,
+		},
+	})
+}
@@ -0,0 +1,208 @@
+package dbexplorer
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileAuditSinkQuery(t *testing.T) {
+
+	sink := &FileAuditSink{Path: filepath.Join(t.TempDir(), "audit.jsonl")}
+
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	entries := []AuditEntry{
+		{Time: start, Table: "items", Key: "1", Operation: AuditCreate, Principal: "alice",
+			After: map[string]interface{}{"title": "a"}},
+		{Time: start.Add(time.Minute), Table: "users", Key: "1", Operation: AuditUpdate, Principal: "bob"},
+		{Time: start.Add(2 * time.Minute), Table: "items", Key: "1", Operation: AuditDelete, Principal: "alice",
+			Before: map[string]interface{}{"title": "a"}},
+	}
+
+	for _, entry := range entries {
+		if err := sink.Write(context.Background(), entry); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+
+	got, err := sink.Query(context.Background(), AuditFilter{Table: "items"})
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+
+	if len(got) != 2 || got[0].Operation != AuditDelete || got[1].Operation != AuditCreate {
+		t.Fatalf("expected items entries newest first, got %+v", got)
+	}
+
+	if got[0].Before["title"] != "a" {
+		t.Fatalf("before image lost: %+v", got[0].Before)
+	}
+
+	got, err = sink.Query(context.Background(), AuditFilter{Since: start.Add(time.Minute), Limit: 1, Offset: 1})
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+
+	if len(got) != 1 || got[0].Principal != "bob" {
+		t.Fatalf("expected bob's update, got %+v", got)
+	}
+}
+
+func TestPrincipal(t *testing.T) {
+
+	h := &Handler{Config: Config{Auth: AuthConfig{
+		Keys: map[string][]string{"k1": {"admin"}},
+	}}.withDefaults()}
+
+	r := httptest.NewRequest(http.MethodPost, "/items/1", nil)
+	r.RemoteAddr = "10.0.0.1:5555"
+	r.Header.Set("X-Principal", "alice")
+
+	if got := h.principal(r); got != "10.0.0.1" {
+		t.Errorf("principal must not come from a client header, got %q", got)
+	}
+
+	r.Header.Set("X-API-Key", "k1")
+
+	got := h.principal(r)
+
+	if !strings.HasPrefix(got, "key:") || strings.Contains(got, "k1") {
+		t.Errorf("expected a fingerprint of the key, got %q", got)
+	}
+
+	r.Header.Set("X-API-Key", "unknown")
+
+	if got := h.principal(r); got != "10.0.0.1" {
+		t.Errorf("unknown key must not become a principal, got %q", got)
+	}
+}
+
+func TestAuditLogRoles(t *testing.T) {
+
+	sink := &FileAuditSink{Path: filepath.Join(t.TempDir(), "audit.jsonl")}
+
+	cases := []struct {
+		roles  []string
+		key    string
+		status int
+	}{
+		{nil, "k1", http.StatusNotFound},
+		{[]string{"auditor"}, "", http.StatusUnauthorized},
+		{[]string{"auditor"}, "k2", http.StatusForbidden},
+		{[]string{"auditor"}, "k1", http.StatusOK},
+	}
+
+	for _, c := range cases {
+
+		h := &Handler{Audit: sink, Config: Config{
+			Audit: AuditConfig{Roles: c.roles},
+			Auth:  AuthConfig{Keys: map[string][]string{"k1": {"auditor"}, "k2": {"reader"}}},
+		}.withDefaults()}
+
+		r := httptest.NewRequest(http.MethodGet, "/_audit", nil)
+		r.Header.Set("X-API-Key", c.key)
+
+		w := httptest.NewRecorder()
+		h.AuditLog(w, r)
+
+		if w.Code != c.status {
+			t.Errorf("roles %v, key %q: got status %d, want %d", c.roles, c.key, w.Code, c.status)
+		}
+	}
+}
+
+// Драйвер без БД для execAudited: INSERT всегда проходит с LastInsertId = 0,
+// любой SELECT - ошибка, фиксации считаются
+type keylessDriver struct {
+	commits int
+}
+
+type keylessConn struct{ d *keylessDriver }
+
+type keylessStmt struct{ query string }
+
+type keylessTx struct{ d *keylessDriver }
+
+func (d *keylessDriver) Open(string) (driver.Conn, error) { return keylessConn{d}, nil }
+
+func (c keylessConn) Prepare(query string) (driver.Stmt, error) { return keylessStmt{query}, nil }
+func (c keylessConn) Close() error                              { return nil }
+func (c keylessConn) Begin() (driver.Tx, error)                 { return keylessTx{c.d}, nil }
+
+func (s keylessStmt) Close() error  { return nil }
+func (s keylessStmt) NumInput() int { return -1 }
+
+// у таблицы без AUTO_INCREMENT ключа LastInsertId = 0
+type keylessResult struct{}
+
+func (keylessResult) LastInsertId() (int64, error) { return 0, nil }
+func (keylessResult) RowsAffected() (int64, error) { return 1, nil }
+
+func (s keylessStmt) Exec([]driver.Value) (driver.Result, error) {
+	return keylessResult{}, nil
+}
+
+func (s keylessStmt) Query([]driver.Value) (driver.Rows, error) {
+	return nil, fmt.Errorf("unexpected query %q", s.query)
+}
+
+func (tx keylessTx) Commit() error   { tx.d.commits++; return nil }
+func (tx keylessTx) Rollback() error { return nil }
+
+type failingAuditSink struct{}
+
+func (failingAuditSink) Write(context.Context, AuditEntry) error { return errors.New("disk full") }
+
+func (failingAuditSink) Query(context.Context, AuditFilter) ([]AuditEntry, error) {
+	return nil, ErrAuditNotQueryable
+}
+
+func TestExecAuditedKeylessTable(t *testing.T) {
+
+	d := &keylessDriver{}
+	db := sql.OpenDB(keylessConnector{d})
+	defer db.Close()
+
+	var out bytes.Buffer
+
+	h := &Handler{DB: db, Audit: &WriterAuditSink{Out: &out}, Config: DefaultConfig().withDefaults()}
+	table := TableInfo{Name: "log", Fields: []FieldInfo{{Name: "msg", ColumnType: "text"}}}
+	r := httptest.NewRequest(http.MethodPut, "/log/", nil)
+
+	_, err := h.execAudited(context.Background(), r, table, AuditCreate, 0, "INSERT INTO log (msg) VALUES (?)", "x")
+
+	if err != nil {
+		t.Fatalf("insert into a table without a key: %v", err)
+	}
+
+	entry := AuditEntry{}
+
+	if err = json.Unmarshal(out.Bytes(), &entry); err != nil || entry.Operation != AuditCreate || entry.After != nil {
+		t.Errorf("got entry %s, %v", out.String(), err)
+	}
+
+	// журнал не записался - изменение не фиксируем
+	h.Audit = failingAuditSink{}
+
+	_, err = h.execAudited(context.Background(), r, table, AuditCreate, 0, "INSERT INTO log (msg) VALUES (?)", "x")
+
+	if err == nil || d.commits != 1 {
+		t.Errorf("failed audit write must roll back: err %v, commits %d", err, d.commits)
+	}
+}
+
+type keylessConnector struct{ d *keylessDriver }
+
+func (c keylessConnector) Connect(context.Context) (driver.Conn, error) { return keylessConn{c.d}, nil }
+func (c keylessConnector) Driver() driver.Driver                        { return c.d }
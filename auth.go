@@ -0,0 +1,81 @@
+package dbexplorer
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+)
+
+// API-ключи клиентов и их роли. Роли нужны эндпоинтам с повышенными правами, например /_sql
+type AuthConfig struct {
+	// Заголовок с API-ключом
+	KeyHeader string `yaml:"key_header"`
+	// Роли по API-ключу
+	Keys map[string][]string `yaml:"keys"`
+}
+
+// API-ключ клиента, если он есть в настройках. Пусто - ключа нет или он неизвестен
+func (h *Handler) apiKey(r *http.Request) string {
+
+	key := r.Header.Get(h.Config.Auth.KeyHeader)
+
+	if key == "" {
+		return ""
+	}
+
+	found := ""
+
+	// сравниваем со всеми ключами за постоянное время, чтобы не подсказывать ключ по таймингу
+	for known := range h.Config.Auth.Keys {
+		if subtle.ConstantTimeCompare([]byte(known), []byte(key)) == 1 {
+			found = known
+		}
+	}
+
+	return found
+}
+
+// Роли клиента по его API-ключу. nil - ключа нет или он неизвестен
+func (h *Handler) roles(r *http.Request) []string {
+
+	key := h.apiKey(r)
+
+	if key == "" {
+		return nil
+	}
+
+	return h.Config.Auth.Keys[key]
+}
+
+// Отпечаток ключа для журналов: сам ключ писать нельзя
+func keyFingerprint(key string) string {
+
+	sum := sha256.Sum256([]byte(key))
+
+	return "key:" + hex.EncodeToString(sum[:6])
+}
+
+// Пускаем только клиентов с одной из ролей allowed. Иначе отвечаем 401 или 403
+func (h *Handler) authorize(w http.ResponseWriter, r *http.Request, allowed []string) bool {
+
+	roles := h.roles(r)
+
+	if roles == nil {
+		writeError(w, r, http.StatusUnauthorized, CodeUnauthorized, "valid API key is required")
+		return false
+	}
+
+	for _, role := range roles {
+		for _, want := range allowed {
+			if role == want {
+				return true
+			}
+		}
+	}
+
+	requestLogger(r).Warn("access denied", "roles", roles, "allowed", allowed)
+	writeError(w, r, http.StatusForbidden, CodeForbidden, "API key has no role allowed here")
+
+	return false
+}
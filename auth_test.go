@@ -0,0 +1,36 @@
+package dbexplorer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAuthorize(t *testing.T) {
+
+	h := &Handler{Config: Config{Auth: AuthConfig{
+		Keys: map[string][]string{"k1": {"analyst"}, "k2": {"reader"}},
+	}}.withDefaults()}
+
+	cases := []struct {
+		key    string
+		status int
+	}{
+		{"", http.StatusUnauthorized},
+		{"nope", http.StatusUnauthorized},
+		{"k2", http.StatusForbidden},
+		{"k1", http.StatusOK},
+	}
+
+	for _, c := range cases {
+
+		r := httptest.NewRequest(http.MethodPost, "/_sql", nil)
+		r.Header.Set("X-API-Key", c.key)
+
+		w := httptest.NewRecorder()
+
+		if ok := h.authorize(w, r, []string{"analyst"}); ok != (c.status == http.StatusOK) || w.Code != c.status {
+			t.Errorf("key %q: got %v, status %d, want %d", c.key, ok, w.Code, c.status)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	dbexplorer "github.com/d3vyatk4ru/db-go-conn-service"
+)
+
+// Настройки сервера. Порядок приоритета: значения по умолчанию, YAML-файл,
+// переменные окружения DB_EXPLORER_*, флаги командной строки
+type ServerConfig struct {
+	DSN             string            `yaml:"dsn"`
+	Listen          string            `yaml:"listen"`
+	TLSCert         string            `yaml:"tls_cert"`
+	TLSKey          string            `yaml:"tls_key"`
+	ReadTimeout     time.Duration     `yaml:"read_timeout"`
+	WriteTimeout    time.Duration     `yaml:"write_timeout"`
+	ShutdownTimeout time.Duration     `yaml:"shutdown_timeout"`
+	Explorer        dbexplorer.Config `yaml:"explorer"`
+}
+
+func defaultServerConfig() ServerConfig {
+	return ServerConfig{
+		Listen:          ":8082",
+		ReadTimeout:     30 * time.Second,
+		WriteTimeout:    60 * time.Second,
+		ShutdownTimeout: 15 * time.Second,
+		Explorer:        dbexplorer.DefaultConfig(),
+	}
+}
+
+// Настройка, которую можно задать флагом и переменной окружения
+type setting struct {
+	name  string
+	usage string
+	set   func(value string) error
+}
+
+// Имя переменной окружения для флага: listen -> DB_EXPLORER_LISTEN
+func (s setting) env() string {
+	return "DB_EXPLORER_" + strings.ToUpper(strings.ReplaceAll(s.name, "-", "_"))
+}
+
+func settings(cfg *ServerConfig) []setting {
+	return []setting{
+		{"dsn", "MySQL DSN, e.g. user:pass@tcp(host:3306)/db", setString(&cfg.DSN)},
+		{"listen", "address to listen on", setString(&cfg.Listen)},
+		{"tls-cert", "TLS certificate file; enables HTTPS together with -tls-key", setString(&cfg.TLSCert)},
+		{"tls-key", "TLS private key file", setString(&cfg.TLSKey)},
+		{"read-timeout", "HTTP server read timeout", setDuration(&cfg.ReadTimeout)},
+		{"write-timeout", "HTTP server write timeout", setDuration(&cfg.WriteTimeout)},
+		{"shutdown-timeout", "graceful shutdown timeout", setDuration(&cfg.ShutdownTimeout)},
+		{"max-open-conns", "maximum number of open DB connections", setInt(&cfg.Explorer.Pool.MaxOpenConns)},
+		{"max-idle-conns", "maximum number of idle DB connections", setInt(&cfg.Explorer.Pool.MaxIdleConns)},
+		{"conn-max-lifetime", "maximum lifetime of a DB connection", setDuration(&cfg.Explorer.Pool.ConnMaxLifetime)},
+		{"conn-max-idle-time", "maximum idle time of a DB connection", setDuration(&cfg.Explorer.Pool.ConnMaxIdleTime)},
+		{"audit-sink", "audit log sink: stdout, file, db or none", setString(&cfg.Explorer.Audit.Sink)},
+		{"audit-file", "audit log file for -audit-sink=file", setString(&cfg.Explorer.Audit.File)},
+		{"audit-roles", "comma separated roles allowed to read /_audit, empty - disabled", setList(&cfg.Explorer.Audit.Roles)},
+		{"rpc-roles", "comma separated roles allowed to call /_rpc, empty - any client", setList(&cfg.Explorer.RPC.Roles)},
+		{"cors-origins", "comma separated list of allowed CORS origins", setList(&cfg.Explorer.CORS.AllowedOrigins)},
+		{"rate-read", "allowed reads per second for one client, 0 - unlimited", setFloat(&cfg.Explorer.RateLimit.Read.Rate)},
+		{"rate-write", "allowed writes per second for one client, 0 - unlimited", setFloat(&cfg.Explorer.RateLimit.Write.Rate)},
+		{"max-limit", "maximum page size for list requests", setInt(&cfg.Explorer.Limits.MaxLimit)},
+		{"statement-timeout", "timeout of a single SQL statement", setDuration(&cfg.Explorer.Limits.StatementTimeout)},
+		{"explain", "refuse full table scans on tables marked as large", setBool(&cfg.Explorer.Limits.Explain)},
+		{"log-level", "log level: debug, info, warn or error", setString(&cfg.Explorer.Log.Level)},
+		{"log-format", "log format: text or json", setString(&cfg.Explorer.Log.Format)},
+		{"log-sql", "log SQL statements", setBool(&cfg.Explorer.Log.SQL)},
+		{"log-payloads", "log SQL arguments and response records", setBool(&cfg.Explorer.Log.Payloads)},
+		{"retry-attempts", "attempts for SQL statements failed with a deadlock, 1 - no retries", setInt(&cfg.Explorer.Retry.Attempts)},
+		{"retry-jitter", "random spread of the retry delay, from 0 to 1", setFloat(&cfg.Explorer.Retry.Jitter)},
+		{"tables-include", "comma separated globs or /regexps/ of exposed tables", setList(&cfg.Explorer.Tables.Include)},
+		{"tables-exclude", "comma separated globs or /regexps/ of hidden tables", setList(&cfg.Explorer.Tables.Exclude)},
+		{"sql-roles", "comma separated roles allowed to run ad-hoc SQL, empty - disabled", setList(&cfg.Explorer.SQL.Roles)},
+		{"sql-timeout", "timeout of an ad-hoc SQL query", setDuration(&cfg.Explorer.SQL.Timeout)},
+		{"sql-max-rows", "maximum rows returned by an ad-hoc SQL query", setInt(&cfg.Explorer.SQL.MaxRows)},
+		{"schema-reload-interval", "reload the schema this often, 0 - never", setDuration(&cfg.Explorer.Schema.ReloadInterval)},
+		{"schema-checksum-interval", "check the schema checksum this often and reload on change, 0 - never",
+			setDuration(&cfg.Explorer.Schema.ChecksumInterval)},
+		{"tracing-exporter", "span exporter: none or stdout", setString(&cfg.Explorer.Tracing.Exporter)},
+		{"export-null", "how NULL is written in CSV responses and read from CSV imports", setString(&cfg.Explorer.Export.Null)},
+		{"import-batch-size", "rows committed by one transaction of an import", setInt(&cfg.Explorer.Import.BatchSize)},
+		{"import-max-errors", "bad lines after which an import stops", setIntPtr(&cfg.Explorer.Import.MaxErrors)},
+		{"import-max-line-bytes", "longest NDJSON line or CSV record of an import", setInt(&cfg.Explorer.Import.MaxLineBytes)},
+		{"import-roles", "comma separated roles allowed to import, empty - disabled", setList(&cfg.Explorer.Import.Roles)},
+	}
+}
+
+// Собираем настройки из файла, окружения и флагов
+func loadConfig(args []string) (ServerConfig, error) {
+
+	cfg := defaultServerConfig()
+
+	fs := flag.NewFlagSet("db-explorer", flag.ContinueOnError)
+
+	configPath := fs.String("config", os.Getenv("DB_EXPLORER_CONFIG"), "path to YAML config file")
+
+	// флаги применяем последними, поэтому пока только запоминаем их
+	flags := make(map[string]string)
+
+	for _, s := range settings(&cfg) {
+		name := s.name
+		fs.Func(name, fmt.Sprintf("%s (env %s)", s.usage, s.env()), func(value string) error {
+			flags[name] = value
+			return nil
+		})
+	}
+
+	err := fs.Parse(args)
+
+	if err != nil {
+		return cfg, err
+	}
+
+	if *configPath != "" {
+
+		data, err := os.ReadFile(*configPath)
+
+		if err != nil {
+			return cfg, err
+		}
+
+		err = yaml.Unmarshal(data, &cfg)
+
+		if err != nil {
+			return cfg, fmt.Errorf("bad config %s: %w", *configPath, err)
+		}
+	}
+
+	for _, s := range settings(&cfg) {
+		if value, ok := os.LookupEnv(s.env()); ok {
+			if err = s.set(value); err != nil {
+				return cfg, fmt.Errorf("bad %s: %w", s.env(), err)
+			}
+		}
+	}
+
+	for _, s := range settings(&cfg) {
+		if value, ok := flags[s.name]; ok {
+			if err = s.set(value); err != nil {
+				return cfg, fmt.Errorf("bad -%s: %w", s.name, err)
+			}
+		}
+	}
+
+	if cfg.DSN == "" {
+		return cfg, fmt.Errorf("DSN is required: use -dsn, DB_EXPLORER_DSN or dsn in config file")
+	}
+
+	if (cfg.TLSCert == "") != (cfg.TLSKey == "") {
+		return cfg, fmt.Errorf("both TLS certificate and key must be set")
+	}
+
+	return cfg, nil
+}
+
+func setString(dst *string) func(string) error {
+	return func(value string) error {
+		*dst = value
+		return nil
+	}
+}
+
+func setList(dst *[]string) func(string) error {
+	return func(value string) error {
+
+		*dst = make([]string, 0)
+
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				*dst = append(*dst, item)
+			}
+		}
+
+		return nil
+	}
+}
+
+func setInt(dst *int) func(string) error {
+	return func(value string) (err error) {
+		*dst, err = strconv.Atoi(value)
+		return err
+	}
+}
+
+// Для чисел, у которых 0 - осмысленное значение, а не "по умолчанию"
+func setIntPtr(dst **int) func(string) error {
+	return func(value string) error {
+
+		n, err := strconv.Atoi(value)
+
+		if err != nil {
+			return err
+		}
+
+		*dst = &n
+
+		return nil
+	}
+}
+
+func setFloat(dst *float64) func(string) error {
+	return func(value string) (err error) {
+		*dst, err = strconv.ParseFloat(value, 64)
+		return err
+	}
+}
+
+func setBool(dst *bool) func(string) error {
+	return func(value string) (err error) {
+		*dst, err = strconv.ParseBool(value)
+		return err
+	}
+}
+
+func setDuration(dst *time.Duration) func(string) error {
+	return func(value string) (err error) {
+		*dst, err = time.ParseDuration(value)
+		return err
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadConfigPrecedence(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "explorer.yaml")
+
+	err := os.WriteFile(path, []byte(`
+dsn: file-dsn
+listen: ":9000"
+explorer:
+  pool:
+    max_open_conns: 4
+  audit:
+    sink: file
+  limits:
+    statement_timeout: 5s
+  import:
+    max_errors: 0
+`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("DB_EXPLORER_LISTEN", ":9001")
+	t.Setenv("DB_EXPLORER_MAX_OPEN_CONNS", "8")
+
+	cfg, err := loadConfig([]string{"-config", path, "-listen", ":9002", "-cors-origins", "https://a, https://b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.DSN != "file-dsn" || cfg.Listen != ":9002" || cfg.Explorer.Pool.MaxOpenConns != 8 {
+		t.Fatalf("bad precedence: %+v", cfg)
+	}
+
+	if cfg.Explorer.Audit.Sink != "file" || cfg.Explorer.Limits.StatementTimeout != 5*time.Second {
+		t.Fatalf("explorer section not loaded: %+v", cfg.Explorer)
+	}
+
+	if cfg.Explorer.Import.MaxErrors == nil || *cfg.Explorer.Import.MaxErrors != 0 {
+		t.Fatalf("max_errors: 0 replaced by default: %v", cfg.Explorer.Import.MaxErrors)
+	}
+
+	if len(cfg.Explorer.CORS.AllowedOrigins) != 2 || cfg.Explorer.CORS.AllowedOrigins[1] != "https://b" {
+		t.Fatalf("bad origins: %v", cfg.Explorer.CORS.AllowedOrigins)
+	}
+
+	if _, err = loadConfig(nil); err == nil {
+		t.Fatal("DSN must be required")
+	}
+}
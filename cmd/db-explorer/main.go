@@ -0,0 +1,104 @@
+// Сервер db-explorer: поднимает HTTP-сервер с NewDBExplorer поверх MySQL.
+//
+//	db-explorer -dsn 'root:1234@tcp(localhost:3306)/golang' -listen :8082
+//	db-explorer -config explorer.yaml
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+
+	dbexplorer "github.com/d3vyatk4ru/db-go-conn-service"
+)
+
+func main() {
+
+	cfg, err := loadConfig(os.Args[1:])
+
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+
+	if err != nil {
+		log.Fatalln("Bad config:", err.Error())
+	}
+
+	db, err := sql.Open("mysql", cfg.DSN)
+
+	if err != nil {
+		log.Fatalln("Bad DSN:", err.Error())
+	}
+
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	err = db.PingContext(ctx)
+	cancel()
+
+	if err != nil {
+		log.Fatalln("Database is unreachable:", err.Error())
+	}
+
+	handler, err := dbexplorer.NewDBExplorerWithConfig(db, cfg.Explorer)
+
+	if err != nil {
+		log.Fatalln("Cant init explorer:", err.Error())
+	}
+
+	// останавливаем фоновое перечитывание схемы до закрытия пула
+	if closer, ok := handler.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	srv := &http.Server{
+		Addr:              cfg.Listen,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+	}
+
+	stop, cancelStop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancelStop()
+
+	serveErr := make(chan error, 1)
+
+	go func() {
+
+		log.Printf("Listening on %s (tls: %v)", cfg.Listen, cfg.TLSCert != "")
+
+		if cfg.TLSCert != "" {
+			serveErr <- srv.ListenAndServeTLS(cfg.TLSCert, cfg.TLSKey)
+		} else {
+			serveErr <- srv.ListenAndServe()
+		}
+	}()
+
+	select {
+	case err = <-serveErr:
+		log.Fatalln("Server stopped:", err.Error())
+	case <-stop.Done():
+	}
+
+	log.Println("Shutting down")
+
+	ctx, cancel = context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	err = srv.Shutdown(ctx)
+
+	if err != nil {
+		log.Println("Bad shutdown:", err.Error())
+	}
+}
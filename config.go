@@ -0,0 +1,207 @@
+package dbexplorer
+
+import "time"
+
+// Настройки сервиса. Нулевые значения полей заменяются значениями из DefaultConfig
+type Config struct {
+	Audit     AuditConfig           `yaml:"audit"`
+	RateLimit RateLimitConfig       `yaml:"rate_limit"`
+	Limits    QueryLimitsConfig     `yaml:"limits"`
+	CORS      CORSConfig            `yaml:"cors"`
+	Pool      PoolConfig            `yaml:"pool"`
+	Health    HealthConfig          `yaml:"health"`
+	Log       LogConfig             `yaml:"log"`
+	Tracing   TracingConfig         `yaml:"tracing"`
+	Retry     RetryConfig           `yaml:"retry"`
+	Schema    SchemaConfig          `yaml:"schema"`
+	Tables    TablesConfig          `yaml:"tables"`
+	RPC       RPCConfig             `yaml:"rpc"`
+	Auth      AuthConfig            `yaml:"auth"`
+	SQL       SQLConfig             `yaml:"sql"`
+	Queries   map[string]SavedQuery `yaml:"queries"`
+	Export    ExportConfig          `yaml:"export"`
+	Import    ImportConfig          `yaml:"import"`
+}
+
+// Настройки журнала аудита изменяющих запросов
+type AuditConfig struct {
+	// Куда пишем журнал: "stdout", "file", "db" или "none"
+	Sink string `yaml:"sink"`
+	// Путь к JSON lines файлу для Sink = "file"
+	File string `yaml:"file"`
+	// Имя таблицы для Sink = "db". Таблица создается при старте и не видна в API
+	Table string `yaml:"table"`
+	// Роли, которым можно читать журнал через /_audit. Пусто - чтение выключено
+	Roles []string `yaml:"roles"`
+}
+
+// Настройки проверок здоровья
+type HealthConfig struct {
+	// Таймаут пинга БД в /readyz
+	PingTimeout time.Duration `yaml:"ping_timeout"`
+}
+
+// Конфигурация по умолчанию. С ней работает NewDBExplorer
+func DefaultConfig() Config {
+	return Config{
+		Audit: AuditConfig{
+			Sink:  AuditSinkStdout,
+			File:  "audit.jsonl",
+			Table: "_audit_log",
+		},
+		Limits: QueryLimitsConfig{
+			DefaultLimit:     5,
+			MaxLimit:         1000,
+			StatementTimeout: 30 * time.Second,
+		},
+		CORS: CORSConfig{
+			AllowedMethods: []string{"GET", "PUT", "POST", "DELETE", "OPTIONS"},
+			AllowedHeaders: []string{"Content-Type", "X-API-Key", "X-Request-ID"},
+			ExposedHeaders: []string{"ETag", "Link", "Retry-After", "X-Request-ID",
+				"X-Total-Count", "X-Limit", "X-Offset"},
+			MaxAge: 10 * time.Minute,
+		},
+		Health: HealthConfig{
+			PingTimeout: 2 * time.Second,
+		},
+		Log: LogConfig{
+			Level:  "info",
+			Format: "text",
+		},
+		Tracing: TracingConfig{
+			Exporter: TracingExporterNone,
+		},
+		Auth: AuthConfig{
+			KeyHeader: "X-API-Key",
+		},
+		SQL: SQLConfig{
+			Timeout: 10 * time.Second,
+			MaxRows: 10000,
+		},
+		Schema: SchemaConfig{
+			Roles: []string{"admin"},
+		},
+		Export: ExportConfig{
+			Null: `\N`,
+		},
+		Import: ImportConfig{
+			BatchSize:    1000,
+			MaxErrors:    intPtr(100),
+			MaxLineBytes: 1 << 20,
+		},
+		Retry: RetryConfig{
+			Attempts:  3,
+			BaseDelay: 50 * time.Millisecond,
+			MaxDelay:  time.Second,
+			Jitter:    0.2,
+		},
+	}
+}
+
+// Заполняем незаданные поля значениями по умолчанию
+func (c Config) withDefaults() Config {
+
+	def := DefaultConfig()
+
+	if c.Audit.Sink == "" {
+		c.Audit.Sink = def.Audit.Sink
+	}
+
+	if c.Audit.File == "" {
+		c.Audit.File = def.Audit.File
+	}
+
+	if c.Audit.Table == "" {
+		c.Audit.Table = def.Audit.Table
+	}
+
+	if c.Limits.DefaultLimit == 0 {
+		c.Limits.DefaultLimit = def.Limits.DefaultLimit
+	}
+
+	if c.Limits.MaxLimit == 0 {
+		c.Limits.MaxLimit = def.Limits.MaxLimit
+	}
+
+	if c.Limits.StatementTimeout == 0 {
+		c.Limits.StatementTimeout = def.Limits.StatementTimeout
+	}
+
+	if c.CORS.AllowedMethods == nil {
+		c.CORS.AllowedMethods = def.CORS.AllowedMethods
+	}
+
+	if c.CORS.AllowedHeaders == nil {
+		c.CORS.AllowedHeaders = def.CORS.AllowedHeaders
+	}
+
+	if c.CORS.ExposedHeaders == nil {
+		c.CORS.ExposedHeaders = def.CORS.ExposedHeaders
+	}
+
+	if c.CORS.MaxAge == 0 {
+		c.CORS.MaxAge = def.CORS.MaxAge
+	}
+
+	if c.Health.PingTimeout == 0 {
+		c.Health.PingTimeout = def.Health.PingTimeout
+	}
+
+	if c.Log.Level == "" {
+		c.Log.Level = def.Log.Level
+	}
+
+	if c.Log.Format == "" {
+		c.Log.Format = def.Log.Format
+	}
+
+	if c.Tracing.Exporter == "" {
+		c.Tracing.Exporter = def.Tracing.Exporter
+	}
+
+	if c.Auth.KeyHeader == "" {
+		c.Auth.KeyHeader = def.Auth.KeyHeader
+	}
+
+	if c.SQL.Timeout == 0 {
+		c.SQL.Timeout = def.SQL.Timeout
+	}
+
+	if c.SQL.MaxRows == 0 {
+		c.SQL.MaxRows = def.SQL.MaxRows
+	}
+
+	if c.Retry.Attempts == 0 {
+		c.Retry.Attempts = def.Retry.Attempts
+	}
+
+	if c.Retry.BaseDelay == 0 {
+		c.Retry.BaseDelay = def.Retry.BaseDelay
+	}
+
+	if c.Retry.MaxDelay == 0 {
+		c.Retry.MaxDelay = def.Retry.MaxDelay
+	}
+
+	if c.Schema.Roles == nil {
+		c.Schema.Roles = def.Schema.Roles
+	}
+
+	if c.Import.BatchSize == 0 {
+		c.Import.BatchSize = def.Import.BatchSize
+	}
+
+	if c.Import.MaxErrors == nil {
+		c.Import.MaxErrors = def.Import.MaxErrors
+	}
+
+	if c.Import.MaxLineBytes == 0 {
+		c.Import.MaxLineBytes = def.Import.MaxLineBytes
+	}
+
+	return c
+}
+
+func intPtr(n int) *int {
+	return &n
+}
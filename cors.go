@@ -0,0 +1,114 @@
+package dbexplorer
+
+import (
+	"errors"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Настройки CORS для браузерных клиентов
+type CORSConfig struct {
+	// Разрешенные Origin. Поддерживаются "*" и шаблоны вида "https://*.example.com".
+	// Пустой список - CORS выключен
+	AllowedOrigins []string `yaml:"allowed_origins"`
+	AllowedMethods []string `yaml:"allowed_methods"`
+	AllowedHeaders []string `yaml:"allowed_headers"`
+	// Заголовки ответа, которые браузер покажет скрипту
+	ExposedHeaders   []string `yaml:"exposed_headers"`
+	AllowCredentials bool     `yaml:"allow_credentials"`
+	// Сколько браузер может кешировать ответ на preflight
+	MaxAge time.Duration `yaml:"max_age"`
+}
+
+// "*" вместе с credentials дал бы любому сайту читать ответы от имени пользователя.
+// Браузеры такое сочетание запрещают, поэтому не отражаем Origin в обход запрета
+func (c CORSConfig) validate() error {
+
+	if !c.AllowCredentials {
+		return nil
+	}
+
+	for _, pattern := range c.AllowedOrigins {
+		if pattern == "*" {
+			return errors.New(`cors: allowed_origins "*" can not be used with allow_credentials`)
+		}
+	}
+
+	return nil
+}
+
+// Origin входит в список разрешенных
+func (c CORSConfig) originAllowed(origin string) bool {
+
+	for _, pattern := range c.AllowedOrigins {
+
+		if pattern == "*" || strings.EqualFold(pattern, origin) {
+			return true
+		}
+
+		if ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(origin)); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Оборачиваем хендлер обработкой CORS. OPTIONS отвечаем сами и дальше не передаем
+func (c CORSConfig) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		origin := r.Header.Get("Origin")
+		allowed := origin != "" && c.originAllowed(origin)
+
+		if origin != "" {
+			w.Header().Add("Vary", "Origin")
+		}
+
+		if allowed {
+
+			// "*" с credentials отсеивает validate
+			if len(c.AllowedOrigins) == 1 && c.AllowedOrigins[0] == "*" {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			} else {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+			}
+
+			if c.AllowCredentials {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+
+			if len(c.ExposedHeaders) > 0 {
+				w.Header().Set("Access-Control-Expose-Headers", strings.Join(c.ExposedHeaders, ", "))
+			}
+		}
+
+		if r.Method != http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Allow", strings.Join(c.AllowedMethods, ", "))
+
+		if allowed && r.Header.Get("Access-Control-Request-Method") != "" {
+
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+
+			w.Header().Set("Access-Control-Allow-Methods", strings.Join(c.AllowedMethods, ", "))
+
+			if len(c.AllowedHeaders) > 0 {
+				w.Header().Set("Access-Control-Allow-Headers", strings.Join(c.AllowedHeaders, ", "))
+			}
+
+			if c.MaxAge > 0 {
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(c.MaxAge.Seconds())))
+			}
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,73 @@
+package dbexplorer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCORSPreflight(t *testing.T) {
+
+	cfg := Config{CORS: CORSConfig{
+		AllowedOrigins:   []string{"https://*.example.com"},
+		AllowCredentials: true,
+	}}.withDefaults().CORS
+
+	called := false
+	handler := cfg.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+
+	req := httptest.NewRequest(http.MethodOptions, "/items", nil)
+	req.Header.Set("Origin", "https://admin.example.com")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if called || rec.Code != http.StatusNoContent {
+		t.Fatalf("preflight must be answered by middleware, got %d", rec.Code)
+	}
+
+	expected := map[string]string{
+		"Access-Control-Allow-Origin":      "https://admin.example.com",
+		"Access-Control-Allow-Credentials": "true",
+		"Access-Control-Allow-Methods":     "GET, PUT, POST, DELETE, OPTIONS",
+		"Access-Control-Max-Age":           "600",
+	}
+
+	for name, value := range expected {
+		if got := rec.Header().Get(name); got != value {
+			t.Errorf("%s: got %q, want %q", name, got, value)
+		}
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/items", nil)
+	req.Header.Set("Origin", "https://evil.com")
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if !called || rec.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Fatal("unknown origin must not get CORS headers")
+	}
+}
+
+func TestCORSValidate(t *testing.T) {
+
+	cases := []struct {
+		cfg CORSConfig
+		ok  bool
+	}{
+		{CORSConfig{AllowedOrigins: []string{"*"}}, true},
+		{CORSConfig{AllowedOrigins: []string{"https://*.example.com"}, AllowCredentials: true}, true},
+		{CORSConfig{AllowedOrigins: []string{"*"}, AllowCredentials: true}, false},
+		{CORSConfig{AllowedOrigins: []string{"https://a.example.com", "*"}, AllowCredentials: true}, false},
+	}
+
+	for _, c := range cases {
+		if err := c.cfg.validate(); (err == nil) != c.ok {
+			t.Errorf("%+v: got %v, want ok=%v", c.cfg, err, c.ok)
+		}
+	}
+}
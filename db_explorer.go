@@ -1,13 +1,17 @@
-package main
+package dbexplorer
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"os"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 )
 
 var TypeInt = "int"
@@ -15,21 +19,43 @@ var TypeText = "text"
 var TypeVarchar = "varchar(255)"
 
 type FieldInfo struct {
-	Name       string
+	// Публичное имя столбца в JSON
+	Name string
+	// Имя столбца в БД. Пусто - совпадает с Name
+	DBName     string
 	ColumnType string
 	IsKey      bool
 	CouldNull  bool
 }
 
 type TableInfo struct {
-	Name   string
+	// Публичное имя таблицы в URL
+	Name string
+	// Имя таблицы в БД. Пусто - совпадает с Name
+	DBName string
+	// Столбец первичного ключа в БД. У представлений - ключ из настроек или пусто
 	ID     string
 	Fields []FieldInfo
+	// Представление (VIEW): только чтение
+	View bool
+	// Столбцы FULLTEXT-индекса в БД для поиска по ?q=. Пусто - индекса нет
+	FullText []string
 }
 
 type Handler struct {
-	DB    *sql.DB
-	Table []TableInfo
+	DB      *sql.DB
+	Config  Config
+	Audit   AuditSink
+	Metrics *Metrics
+	Logger  *slog.Logger
+	// Получатель спанов. nil - трассировка выключена
+	SpanExporter SpanExporter
+
+	// Текущий снимок схемы, см. Schema и ReloadSchema
+	schema   atomic.Pointer[Schema]
+	reloadMu sync.Mutex
+	// Сохраненные запросы из Config.Queries
+	queries map[string]*compiledQuery
 }
 
 type Columns struct {
@@ -41,40 +67,42 @@ type Columns struct {
 	Extra   string
 }
 
-// Хендлер для списка всех таблиц. Вызывается по эндпоинту "/" [GET]
-func (h *Handler) TableList(w http.ResponseWriter) {
+// Хендлер для списка всех таблиц и представлений. Вызывается по эндпоинту "/" [GET]
+func (h *Handler) TableList(w http.ResponseWriter, r *http.Request) {
 
 	tables := make([]string, 0)
+	views := make([]string, 0)
+
+	for _, table := range h.tables() {
+
+		if table.View {
+			views = append(views, table.Name)
+			continue
+		}
 
-	for _, table := range h.Table {
 		tables = append(tables, table.Name)
 	}
 
-	log.Println(tables)
-
-	result, err := json.Marshal(
-		map[string]interface{}{
-			"response": map[string][]string{
-				"tables": tables,
-			},
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"response": map[string][]string{
+			"tables": tables,
+			"views":  views,
 		},
-	)
+	})
+}
 
-	if err != nil {
-		log.Println("Bad packed json:", err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
+// Представления доступны только на чтение. Отвечаем 405, если table - представление
+func readOnlyView(w http.ResponseWriter, r *http.Request, table TableInfo) bool {
 
-	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Content-Type", "application/json")
+	if !table.View {
+		return false
+	}
 
-	// send array of bytes to client
-	_, err = w.Write(result)
+	w.Header().Set("Allow", http.MethodGet)
+	writeError(w, r, http.StatusMethodNotAllowed, CodeReadOnlyTable,
+		fmt.Sprintf("%s is a view and is read-only", table.Name))
 
-	if err != nil {
-		log.Println("Bad request:", err.Error())
-	}
+	return true
 }
 
 // Хендлер для получния записи / записей по id. Вызывается по эндпоинту "/{table}/{id}" [GET]
@@ -86,20 +114,12 @@ func (h *Handler) SelectRecordByID(w http.ResponseWriter, r *http.Request) {
 
 	table := params[1]
 
-	cond, idx, err := contains(h.Table, table)
+	tables := h.tables()
 
-	if !cond {
-
-		w.WriteHeader(http.StatusNotFound)
-		w.Header().Set("Content-Type", "application/json")
-		_, err2 := w.Write(
-			[]byte(`{"error" : "unknown table"}`),
-		)
-
-		if err2 != nil {
-			log.Println("Bad packed json:", err2.Error())
-		}
+	cond, idx, err := contains(tables, table)
 
+	if !cond {
+		writeError(w, r, http.StatusNotFound, CodeUnknownTable, fmt.Sprintf("unknown table %s", table))
 		return
 	}
 
@@ -107,72 +127,53 @@ func (h *Handler) SelectRecordByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	id, err := strconv.Atoi(params[2])
-
-	if err != nil {
-		log.Printf("[GetRecordById] GET '/%v/%v'. Bad converted id to int.\n Error: %v",
-			table, id, err.Error())
-
-		w.WriteHeader(http.StatusBadRequest)
+	if tables[idx].ID == "" {
+		writeError(w, r, http.StatusNotFound, CodeNoKey,
+			fmt.Sprintf("%s has no key, records can not be read by id", table))
 		return
 	}
 
-	values := ColumnsType(h.Table[idx])
-
-	columns := GetColumnsTable(h.Table[idx], false)
-
-	err = h.DB.
-		QueryRow(
-			fmt.Sprintf("SELECT %s FROM %s WHERE %s = %v",
-				columns, h.Table[idx].Name, h.Table[idx].ID, id),
-		).
-		Scan(values...)
+	id, err := strconv.Atoi(params[2])
 
 	if err != nil {
-		log.Printf("[GetRecordById] GET '/%v/%v'. Bad scanned to table %v. Error: %v",
-			h.Table[idx].Name, id, table, err.Error())
+		requestLogger(r).Warn("bad record id", "table", table, "id", params[2], "error", err)
+		writeError(w, r, http.StatusBadRequest, CodeInvalidID, "record id must be an integer")
+		return
+	}
 
-		result, err2 := json.Marshal(
-			map[string]string{
-				"error": "record not found",
-			})
+	values := ColumnsType(tables[idx])
 
-		if err2 != nil {
-			log.Println("Bad packed json:", err.Error())
-		}
+	columns := GetColumnsTable(tables[idx], false)
 
-		w.WriteHeader(http.StatusNotFound)
-		w.Header().Set("Content-Type", "application/json")
-		_, err = w.Write(result)
+	ctx, cancel := h.operationContext(r.Context(), "get")
+	defer cancel()
 
-		if err != nil {
-			log.Println("Bad request: ", err.Error())
-		}
+	err = h.queryRow(ctx, table, "get", values,
+		fmt.Sprintf("SELECT %s FROM %s WHERE %s = %v",
+			columns, tables[idx].Table(), tables[idx].ID, id),
+	)
 
+	if err != nil && h.contextError(ctx, w, r, err) {
 		return
 	}
 
-	data := CastType(values, h.Table[idx])
-
-	result, err := json.Marshal(
-		map[string]interface{}{
-			"response": map[string]interface{}{
-				"record": data,
-			},
-		},
-	)
-
-	if err != nil {
-		log.Println("Bad packed json")
+	if err != nil && err != sql.ErrNoRows {
+		requestLogger(r).Error("bad query", "table", table, "id", id, "error", err)
+		writeInternalError(w, r)
+		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Content-Type", "appliсation/json")
-	_, err = w.Write(result)
-
 	if err != nil {
-		log.Println("Bad request")
+		requestLogger(r).Info("record not found", "table", table, "id", id)
+		writeError(w, r, http.StatusNotFound, CodeNotFound, "record not found")
+		return
 	}
+
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"response": map[string]interface{}{
+			"record": CastType(values, tables[idx]),
+		},
+	})
 }
 
 // Хендлер для полуения записений из таблицы с лимитом и оффсетом.
@@ -183,113 +184,180 @@ func (h *Handler) SelectRecord(w http.ResponseWriter, r *http.Request) {
 
 	table := strings.Split(url, "/")[1]
 
-	cond, idx, err := contains(h.Table, table)
+	tables := h.tables()
 
-	if !cond {
+	cond, idx, err := contains(tables, table)
 
-		w.WriteHeader(http.StatusNotFound)
-		w.Header().Set("Content-Type", "application/json")
-		_, err2 := w.Write(
-			[]byte(`{"error" : "unknown table"}`),
-		)
-
-		if err2 != nil {
-			log.Println("Bad packed json:", err2.Error())
-		}
+	if !cond {
+		writeError(w, r, http.StatusNotFound, CodeUnknownTable, fmt.Sprintf("unknown table %s", table))
+		return
+	}
 
+	if err != nil {
 		return
 	}
 
+	lim, off, err := h.pageParams(table, r)
+
 	if err != nil {
+		writeProblem(w, r, pageProblem(err))
 		return
 	}
 
-	limit := r.URL.Query().Get("limit")
+	where, args, err := filterParams(tables[idx], r, listParams, h.Config.Limits.StrictFilters)
 
-	if limit == "" {
-		limit = "5"
+	if err != nil {
+		writeProblem(w, r, err.(*Problem))
+		return
 	}
 
-	lim, err := strconv.Atoi(limit)
+	format, err := streamFormat(r)
+
 	if err != nil {
-		lim = 5
+		writeProblem(w, r, err.(*Problem))
+		return
 	}
 
-	offset := r.URL.Query().Get("offset")
+	// заголовки CSV - публичные имена полей
+	names := make([]string, 0, len(tables[idx].Fields)+1)
 
-	if offset == "" {
-		offset = "0"
+	for _, field := range tables[idx].Fields {
+		names = append(names, field.Name)
 	}
 
-	off, err := strconv.Atoi(offset)
-	if err != nil {
-		off = 0
+	// у представления без ключа сортируем по первому столбцу, чтобы страницы не плыли
+	order := tables[idx].ID
+
+	if order == "" {
+		order = "1"
 	}
 
-	values := ColumnsType(h.Table[idx])
+	values := ColumnsType(tables[idx])
+
+	columns := GetColumnsTable(tables[idx], false)
 
-	columns := GetColumnsTable(h.Table[idx], false)
+	var found *search
 
-	log.Println("columns: ", columns)
+	if r.URL.Query().Has("q") {
+
+		found, err = buildSearch(tables[idx], r.URL.Query().Get("q"))
+
+		if err != nil {
+			writeProblem(w, r, err.(*Problem))
+			return
+		}
+
+		// релевантность идет последним столбцом и первым ключом сортировки
+		var score float64
+
+		values = append(values, &score)
+		columns += ", " + found.Score + " AS _score"
+		names = append(names, "_score")
+		order = "_score DESC, " + order
+
+		if where != "" {
+			where += " AND "
+		}
+
+		where += found.Where
+		args = append(append(found.ScoreArgs, args...), found.WhereArgs...)
+	}
+
+	if where != "" {
+		where = " WHERE " + where
+	}
 
 	query := fmt.Sprintf(
-		"SELECT %s FROM %v ORDER BY %v LIMIT %d, %d",
-		columns, h.Table[idx].Name, h.Table[idx].ID, off, lim,
+		"SELECT %s FROM %v%s ORDER BY %v LIMIT %d, %d",
+		columns, tables[idx].Table(), where, order, off, lim,
 	)
 
-	log.Println(query)
+	ctx, cancel := h.operationContext(r.Context(), "list")
+	defer cancel()
+
+	err = h.checkQueryCost(ctx, table, query, args...)
+
+	if err == ErrFullScan {
+		writeError(w, r, http.StatusBadRequest, CodeFullScan, err.Error())
+		return
+	}
+
+	if err != nil && h.contextError(ctx, w, r, err) {
+		return
+	}
 
-	rows, err := h.DB.Query(query)
+	if err != nil {
+		requestLogger(r).Warn("bad explain of query", "table", table, "error", err)
+	}
 
-	defer rows.Close() //nolint:staticcheck
+	rows, err := h.query(ctx, table, "list", query, args...)
 
 	if err != nil {
-		log.Printf("[TableContain] GET '/%v?limit=%v&offfset=%v'. Bad query to table %v.\n Error: %v",
-			table, lim, off, table, err.Error())
-		w.WriteHeader(500)
+		h.dbError(ctx, w, r, err, "bad query", "table", table, "limit", lim, "offset", off)
 		return
 	}
 
-	data := make([]interface{}, 0)
+	defer rows.Close()
+
+	_, span := startSpan(r.Context(), "json.encode")
+
+	stream := newRecordStream(w, format, names, h.exportNull(r))
 
 	for rows.Next() {
 
 		err = rows.Scan(values...)
 
 		if err != nil {
-			log.Printf("[TableContain] GET '/%v?limit=%v&offfset=%v'. Bad scanned to table %v.\n Error: %v",
-				table, limit, offset, table, err.Error())
-			w.WriteHeader(500)
+			break
+		}
+
+		var record map[string]interface{}
+
+		if found == nil {
+			record = CastType(values, tables[idx])
+		} else {
+			record = CastType(values[:len(values)-1], tables[idx])
+			record["_score"] = *values[len(values)-1].(*float64)
+
+			if snippetsWanted(r) {
+				record["_snippets"] = snippets(tables[idx], record, found.Tokens)
+			}
+		}
+
+		if h.Config.Log.Payloads {
+			requestLogger(r).Info("response record", "table", table, "record", record)
+		}
+
+		err = stream.Write(record)
+
+		if err != nil {
+			requestLogger(r).Warn("bad write of response", "table", table, "records", stream.count, "error", err)
+			span.Finish()
 			return
 		}
+	}
 
-		data = append(data, CastType(values, h.Table[idx]))
+	if err == nil {
+		err = rows.Err()
 	}
 
-	result, err := json.Marshal(
-		map[string]interface{}{
-			"response": map[string]interface{}{
-				"records": data,
-			},
-		},
-	)
+	h.rowsReturned(r.Context(), table, "list", stream.count)
 
 	if err != nil {
-		log.Printf("[TableContain] GET '/%v?limit=%v&offfset=%v'. Bad json marshal.\n Error: %v",
-			table, limit, offset, err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+		requestLogger(r).Error("bad rows", "table", table, "limit", lim, "offset", off,
+			"records", stream.count, "error", err)
 	}
 
-	log.Println(data)
+	sent := stream.Close(ctx, r, err)
 
-	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Content-Type", "application/json")
-	_, err = w.Write(result)
+	span.SetAttr("json.bytes", stream.bytes)
+	span.Finish()
 
-	if err != nil {
-		log.Println("Bad request")
+	if sent || h.contextError(ctx, w, r, err) {
+		return
 	}
+
+	writeInternalError(w, r)
 }
 
 // Хендлер для создания новой записи. Параметры передаются в теле.
@@ -302,20 +370,12 @@ func (h *Handler) CreateRecord(w http.ResponseWriter, r *http.Request) {
 
 	table := params[1]
 
-	cond, idx, err := contains(h.Table, table)
+	tables := h.tables()
 
-	if !cond {
-
-		w.WriteHeader(http.StatusNotFound)
-		w.Header().Set("Content-Type", "application/json")
-		_, err2 := w.Write(
-			[]byte(`{"error" : "unknown table"}`),
-		)
-
-		if err2 != nil {
-			log.Println("Bad packed json:", err2.Error())
-		}
+	cond, idx, err := contains(tables, table)
 
+	if !cond {
+		writeError(w, r, http.StatusNotFound, CodeUnknownTable, fmt.Sprintf("unknown table %s", table))
 		return
 	}
 
@@ -323,66 +383,50 @@ func (h *Handler) CreateRecord(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	item, placeholder, err := MakeContainerInsert(h.Table[idx], r)
-
-	if err != nil {
-		w.WriteHeader(500)
-		_, err2 := w.Write([]byte(`{"error": "bad unpacked json"}`))
+	if readOnlyView(w, r, tables[idx]) {
+		return
+	}
 
-		if err2 != nil {
-			log.Println("Bad request:", err2.Error())
-		}
+	item, placeholder, err := MakeContainerInsert(tables[idx], r)
 
+	if err != nil {
+		requestLogger(r).Warn("bad decode json data", "table", table, "error", err)
+		writeError(w, r, http.StatusBadRequest, CodeInvalidBody, "request body must be a JSON object")
 		return
 	}
 
-	columns := GetColumnsTable(h.Table[idx], true)
+	columns := GetColumnsTable(tables[idx], true)
 
 	query := fmt.Sprintf(
 		"INSERT INTO %v (%v) VALUES (%v);",
-		h.Table[idx].Name, columns, placeholder,
+		tables[idx].Table(), columns, placeholder,
 	)
 
-	log.Println(query)
+	ctx, cancel := h.operationContext(r.Context(), "create")
+	defer cancel()
 
-	res, err := h.DB.Exec(query, item...)
+	res, err := h.execAudited(ctx, r, tables[idx], AuditCreate, 0, query, item...)
 
 	if err != nil {
-		log.Printf("[CreateRecord] Bad Execute query! Error: %v", err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
+		h.tableError(ctx, w, r, tables[idx], err, "bad insert", "table", table)
 		return
 	}
 
 	LastID, err := res.LastInsertId()
 
 	if err != nil {
-		log.Printf("[CreateRecord] Bad called RowsAffected()! Error: %v",
-			err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
+		requestLogger(r).Error("bad last insert id", "table", table, "error", err)
+		writeInternalError(w, r)
 		return
 	}
 
-	NameID := GetIDColumnName(h.Table[idx])
-
-	result, err := json.Marshal(
-		map[string]interface{}{
-			"response": map[string]int{
-				NameID: int(LastID),
-			},
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"response": map[string]int64{
+			GetIDColumnName(tables[idx]): LastID,
 		},
-	)
+	})
 
-	if err != nil {
-		log.Println("Bad packed json:", err.Error())
-	}
-
-	_, err = w.Write(result)
-
-	if err != nil {
-		log.Println("Bad request:", err.Error())
-	}
-
-	log.Println("Inserted ID:", LastID)
+	requestLogger(r).Info("record created", "table", table, "id", LastID)
 }
 
 // Хендлер для обновлени существующей записи по ID. Параметры передаются в теле.
@@ -395,20 +439,12 @@ func (h *Handler) UpdateRecord(w http.ResponseWriter, r *http.Request) {
 
 	table := params[1]
 
-	cond, idx, err := contains(h.Table, table)
+	tables := h.tables()
 
-	if !cond {
-
-		w.WriteHeader(http.StatusNotFound)
-		w.Header().Set("Content-Type", "application/json")
-		_, err2 := w.Write(
-			[]byte(`{"error" : "unknown table"}`),
-		)
-
-		if err2 != nil {
-			log.Println("Bad packed json:", err2.Error())
-		}
+	cond, idx, err := contains(tables, table)
 
+	if !cond {
+		writeError(w, r, http.StatusNotFound, CodeUnknownTable, fmt.Sprintf("unknown table %s", table))
 		return
 	}
 
@@ -416,94 +452,61 @@ func (h *Handler) UpdateRecord(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if readOnlyView(w, r, tables[idx]) {
+		return
+	}
+
 	id, err := strconv.Atoi(params[2])
 
 	if err != nil {
-		log.Printf("[UpdateRecord] POST '/%v/%v'. Bad converted id to int. Error: %v",
-			table, id, err.Error())
-		w.WriteHeader(http.StatusBadRequest)
+		requestLogger(r).Warn("bad record id", "table", table, "id", params[2], "error", err)
+		writeError(w, r, http.StatusBadRequest, CodeInvalidID, "record id must be an integer")
 		return
 	}
 
-	columnIDName, placeholder, item, err := CheckParamsAndTypes(h.Table[idx], r)
+	_, placeholder, item, err := CheckParamsAndTypes(tables[idx], r)
 
 	if err != nil {
-		log.Printf("Cant update %s", columnIDName)
-
-		w.WriteHeader(http.StatusBadRequest)
-		_, err2 := w.Write(
-			[]byte(
-				fmt.Sprintf(`{"error" : "field %s have invalid type"}`, columnIDName),
-			),
-		)
-
-		if err2 != nil {
-			log.Println("Bad request:", err2.Error())
-		}
+		requestLogger(r).Warn("bad update params", "table", table, "error", err)
+		writeProblem(w, r, err.(*Problem))
 		return
 	}
 
-	if strings.Contains(placeholder, columnIDName) {
-
-		log.Printf("Cant update %s", columnIDName)
-
-		w.WriteHeader(http.StatusBadRequest)
-		_, err2 := w.Write(
-			[]byte(
-				fmt.Sprintf(`{"error" : "field %s have invalid type"}`, columnIDName),
-			),
-		)
-
-		if err2 != nil {
-			log.Println("Bad request:", err2.Error())
-		}
+	if placeholder == "" {
+		writeError(w, r, http.StatusBadRequest, CodeInvalidBody, "nothing to update")
 		return
 	}
 
+	ctx, cancel := h.operationContext(r.Context(), "update")
+	defer cancel()
+
 	query := fmt.Sprintf(
 		"UPDATE %v SET %v WHERE %v = %d",
-		h.Table[idx].Name, placeholder, columnIDName, id,
+		tables[idx].Table(), placeholder, tables[idx].ID, id,
 	)
 
-	log.Println(query)
-
-	res, err := h.DB.Exec(query, item...)
+	res, err := h.execAudited(ctx, r, tables[idx], AuditUpdate, int64(id), query, item...)
 
 	if err != nil {
-		log.Printf("[UpdateRecord] Bad Execute query! Error: %v",
-			err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
+		h.tableError(ctx, w, r, tables[idx], err, "bad update", "table", table, "id", id)
 		return
 	}
 
 	affected, err := res.RowsAffected()
 
 	if err != nil {
-		log.Printf("[UpdateRecord] Bad called RowsAffected()! Error: %v",
-			err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
+		requestLogger(r).Error("bad rows affected", "table", table, "id", id, "error", err)
+		writeInternalError(w, r)
 		return
 	}
 
-	result, err := json.Marshal(
-		map[string]interface{}{
-			"response": map[string]int{
-				"updated": int(affected),
-			},
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"response": map[string]int64{
+			"updated": affected,
 		},
-	)
-
-	if err != nil {
-		log.Println("Bad packed json:", err.Error())
-	}
-
-	_, err = w.Write(result)
-
-	if err != nil {
-		log.Println("Bad request:", err.Error())
-	}
+	})
 
-	log.Println("Row affected:", affected)
+	requestLogger(r).Info("record updated", "table", table, "id", id, "affected", affected)
 }
 
 // Хендлер для удлаения записи по ID. Вызывается по эндпоинту "/{table}/{id}". [DELETE]
@@ -515,20 +518,12 @@ func (h *Handler) DeleteRecord(w http.ResponseWriter, r *http.Request) {
 
 	table := params[1]
 
-	cond, idx, err := contains(h.Table, table)
-
-	if !cond {
-
-		w.WriteHeader(http.StatusNotFound)
-		w.Header().Set("Content-Type", "application/json")
-		_, err2 := w.Write(
-			[]byte(`{"error" : "unknown table"}`),
-		)
+	tables := h.tables()
 
-		if err2 != nil {
-			log.Println("Bad packed json:", err2.Error())
-		}
+	cond, idx, err := contains(tables, table)
 
+	if !cond {
+		writeError(w, r, http.StatusNotFound, CodeUnknownTable, fmt.Sprintf("unknown table %s", table))
 		return
 	}
 
@@ -536,59 +531,72 @@ func (h *Handler) DeleteRecord(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if readOnlyView(w, r, tables[idx]) {
+		return
+	}
+
 	id, err := strconv.Atoi(params[2])
 
 	if err != nil {
-		log.Printf("[DeleteRecord] DELETE '/%v/%v'. Bad converted id to int. Error: %v",
-			table, id, err.Error())
-		w.WriteHeader(http.StatusBadRequest)
+		requestLogger(r).Warn("bad record id", "table", table, "id", params[2], "error", err)
+		writeError(w, r, http.StatusBadRequest, CodeInvalidID, "record id must be an integer")
 		return
 	}
 
-	NameID := GetIDColumnName(h.Table[idx])
+	ctx, cancel := h.operationContext(r.Context(), "delete")
+	defer cancel()
 
 	query := fmt.Sprintf(
-		"DELETE FROM %v WHERE %v = %d", h.Table[idx].Name, NameID, id,
+		"DELETE FROM %v WHERE %v = %d", tables[idx].Table(), tables[idx].ID, id,
 	)
 
-	log.Println(query)
-
-	res, err := h.DB.Exec(query)
+	res, err := h.execAudited(ctx, r, tables[idx], AuditDelete, int64(id), query)
 
 	if err != nil {
-		log.Printf("[DeleteRecord] Bad Execute query! Error: %v", err.Error())
-		w.WriteHeader(http.StatusBadRequest)
+		h.tableError(ctx, w, r, tables[idx], err, "bad delete", "table", table, "id", id)
 		return
 	}
 
 	affected, err := res.RowsAffected()
 
 	if err != nil {
-		log.Printf("[DeleteRecord] Bad called RowsAffected()! \nError: %v", err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		requestLogger(r).Error("bad rows affected", "table", table, "id", id, "error", err)
+		writeInternalError(w, r)
 		return
 	}
 
-	result, err := json.Marshal(
-		map[string]interface{}{
-			"response": map[string]int{
-				"deleted": int(affected),
-			},
+	requestLogger(r).Info("record deleted", "table", table, "id", id, "affected", affected)
+
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"response": map[string]int64{
+			"deleted": affected,
 		},
-	)
+	})
+}
+
+// Пакуем ответ в json и отправляем клиенту
+func writeJSON(w http.ResponseWriter, r *http.Request, status int, body interface{}) {
+
+	_, span := startSpan(r.Context(), "json.encode")
+
+	result, err := json.Marshal(body)
+
+	span.SetAttr("json.bytes", len(result))
+	span.Finish()
 
 	if err != nil {
-		log.Println("Bad packed json:", err.Error())
+		requestLogger(r).Error("bad packed json", "error", err)
+		writeInternalError(w, r)
+		return
 	}
 
-	log.Println(result)
-
-	w.WriteHeader(http.StatusOK)
 	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
 	_, err = w.Write(result)
 
 	if err != nil {
-		log.Println("Bad request:", err.Error())
+		requestLogger(r).Warn("bad write of response", "error", err)
 	}
 }
 
@@ -605,6 +613,39 @@ func GetIDColumnName(table TableInfo) string {
 	return ""
 }
 
+// Читаем запись по первичному ключу. Если записи нет - возвращаем nil без ошибки.
+// lock - держать запись до конца транзакции db
+func (h *Handler) fetchRecordOn(ctx context.Context, db querier, table TableInfo, id int64,
+	lock bool) (map[string]interface{}, error) {
+
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE %s = ?",
+		GetColumnsTable(table, false), table.Table(), table.ID)
+
+	if lock {
+		query += " FOR UPDATE"
+	}
+
+	rows, err := h.queryOn(ctx, db, table.Name, "get", query, id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+
+	values := ColumnsType(table)
+
+	if err = rows.Scan(values...); err != nil {
+		return nil, err
+	}
+
+	return CastType(values, table), rows.Err()
+}
+
 // Проверка наличия конкретной таблицы
 func contains(s []TableInfo, table string) (bool, int, error) {
 	for idx, v := range s {
@@ -631,7 +672,7 @@ func GetColumnsTable(table TableInfo, key bool) string {
 			}
 		}
 
-		columns = append(columns, field.Name)
+		columns = append(columns, field.Column())
 	}
 
 	return strings.Join(columns, ",")
@@ -648,7 +689,6 @@ func MakeContainerInsert(table TableInfo, r *http.Request) ([]interface{}, strin
 	err := decoder.Decode(&param)
 
 	if err != nil {
-		log.Println("Bad decode json data: ", err.Error())
 		return make([]interface{}, 0), "", err
 	}
 
@@ -679,7 +719,8 @@ func MakeContainerInsert(table TableInfo, r *http.Request) ([]interface{}, strin
 	return item, strings.Join(placeholder, ","), nil
 }
 
-// Проверка типов параметров, которые пришли в реквесте. Делаем placeholders
+// Проверка типов параметров, которые пришли в реквесте. Делаем placeholders.
+// Ошибки валидации возвращаются как *Problem с именем поля
 func CheckParamsAndTypes(table TableInfo, r *http.Request) (string, string, []interface{}, error) {
 
 	var columnIDName string
@@ -692,8 +733,8 @@ func CheckParamsAndTypes(table TableInfo, r *http.Request) (string, string, []in
 	err := decoder.Decode(&param)
 
 	if err != nil {
-		log.Println("Bad decode json data")
-		return "", "", make([]interface{}, 0), err
+		return "", "", make([]interface{}, 0),
+			NewProblem(http.StatusBadRequest, CodeInvalidBody, "request body must be a JSON object")
 	}
 
 	for _, field := range table.Fields {
@@ -706,38 +747,65 @@ func CheckParamsAndTypes(table TableInfo, r *http.Request) (string, string, []in
 
 	for key, val := range param {
 
-		for _, field := range table.Fields {
+		field, ok := fieldByName(table, key)
 
-			if field.Name == key {
+		if !ok {
+			return columnIDName, "", make([]interface{}, 0), FieldProblem(http.StatusBadRequest,
+				CodeUnknownField, key, fmt.Sprintf("field %s does not exist", key))
+		}
 
-				if val == nil && !field.CouldNull {
-					return field.Name, "", make([]interface{}, 0), fmt.Errorf("%s column cant use this type", field.Name)
-				}
+		if field.IsKey {
+			return columnIDName, "", make([]interface{}, 0), FieldProblem(http.StatusBadRequest,
+				CodeReadOnlyField, key, fmt.Sprintf("field %s is a primary key and can not be updated", key))
+		}
 
-				switch val.(type) {
+		if val == nil && !field.CouldNull {
+			return columnIDName, "", make([]interface{}, 0), FieldProblem(http.StatusBadRequest,
+				CodeNotNull, key, fmt.Sprintf("field %s can not be null", key))
+		}
 
-				case string:
+		switch val.(type) {
 
-					if field.ColumnType != TypeVarchar && field.ColumnType != TypeText {
-						return field.Name, "", make([]interface{}, 0), fmt.Errorf("%s column cant use this type", field.Name)
-					}
+		case string:
 
-				case float64:
-					if field.ColumnType != TypeInt {
-						return field.Name, "", make([]interface{}, 0), fmt.Errorf("%s column cant use this type", field.Name)
-					}
-				}
+			if field.ColumnType != TypeVarchar && field.ColumnType != TypeText {
+				return columnIDName, "", make([]interface{}, 0), FieldProblem(http.StatusBadRequest,
+					CodeInvalidType, key, fmt.Sprintf("field %s must be %s, got string", key, field.ColumnType))
 			}
+
+		case float64:
+			if field.ColumnType != TypeInt {
+				return columnIDName, "", make([]interface{}, 0), FieldProblem(http.StatusBadRequest,
+					CodeInvalidType, key, fmt.Sprintf("field %s must be %s, got number", key, field.ColumnType))
+			}
+
+		case nil:
+
+		default:
+			return columnIDName, "", make([]interface{}, 0), FieldProblem(http.StatusBadRequest,
+				CodeInvalidType, key, fmt.Sprintf("field %s must be %s", key, field.ColumnType))
 		}
 
 		item = append(item, val)
 
-		placeholder = append(placeholder, fmt.Sprintf("%v = ?", key))
+		placeholder = append(placeholder, fmt.Sprintf("%v = ?", field.Column()))
 	}
 
 	return columnIDName, strings.Join(placeholder, ","), item, nil
 }
 
+// Ищем столбец таблицы по имени
+func fieldByName(table TableInfo, name string) (FieldInfo, bool) {
+
+	for _, field := range table.Fields {
+		if field.Name == name {
+			return field, true
+		}
+	}
+
+	return FieldInfo{}, false
+}
+
 // Возвращаем интерфейс с подготовленными типами для
 // чтения записи из таблицы table
 func ColumnsType(table TableInfo) []interface{} {
@@ -748,7 +816,8 @@ func ColumnsType(table TableInfo) []interface{} {
 		switch field.ColumnType {
 		case TypeInt:
 			values[i] = new(sql.NullInt64)
-		case TypeVarchar, TypeText:
+		default:
+			// остальные типы (в том числе столбцы представлений) отдаем строкой
 			values[i] = new(sql.NullString)
 		}
 	}
@@ -783,21 +852,50 @@ func CastType(record []interface{}, table TableInfo) map[string]interface{} {
 	return item
 }
 
+// Имена представлений (VIEW) в БД
+func GetAllViews(ctx context.Context, db *sql.DB) (map[string]bool, error) {
+
+	views := make(map[string]bool)
+
+	rows, err := db.QueryContext(ctx, `SHOW FULL TABLES WHERE Table_type = 'VIEW'`)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+
+		var name, kind string
+
+		err = rows.Scan(&name, &kind)
+
+		if err != nil {
+			return nil, err
+		}
+
+		views[name] = true
+	}
+
+	return views, rows.Err()
+}
+
 // Информация о все таблицах в БД
-func GetAllTables(db *sql.DB) ([]string, error) {
+func GetAllTables(ctx context.Context, db *sql.DB) ([]string, error) {
 
 	tables := make([]string, 0)
 
 	// make request to db. Get all tables name
-	rows, err := db.Query(`SHOW TABLES;`)
-
-	// auto close after returns
-	defer rows.Close() //nolint:staticcheck
+	rows, err := db.QueryContext(ctx, `SHOW TABLES;`)
 
 	if err != nil {
 		return tables, err
 	}
 
+	// auto close after returns
+	defer rows.Close()
+
 	// iteration over returned query from db and read data
 	for rows.Next() {
 
@@ -812,80 +910,99 @@ func GetAllTables(db *sql.DB) ([]string, error) {
 		tables = append(tables, table)
 	}
 
-	return tables, nil
+	return tables, rows.Err()
 }
 
 // Получение информации о всех столбцах таблицы
-func GetTablesInfo(db *sql.DB) ([]TableInfo, error) {
+func GetTablesInfo(ctx context.Context, db *sql.DB) ([]TableInfo, error) {
 
 	tableInfo := []TableInfo{}
 
-	tables, err := GetAllTables(db)
+	tables, err := GetAllTables(ctx, db)
 
 	if err != nil {
 		return nil, err
 	}
 
-	for _, table := range tables {
+	views, err := GetAllViews(ctx, db)
 
-		fieldInfo := []FieldInfo{}
+	if err != nil {
+		return nil, err
+	}
 
-		var nameID string
+	for _, table := range tables {
 
-		rows, err := db.Query(
-			fmt.Sprintf(`SHOW COLUMNS FROM %s`, table),
-		)
+		fieldInfo, nameID, err := getColumns(ctx, db, table)
 
 		if err != nil {
 			return nil, err
 		}
 
-		col := Columns{}
+		tableInfo = append(
+			tableInfo,
+			TableInfo{
+				Name:   table,
+				ID:     nameID,
+				Fields: fieldInfo,
+				View:   views[table],
+			},
+		)
+	}
 
-		for rows.Next() {
+	return tableInfo, nil
+}
 
-			var null bool
-			var isKey bool
+// Столбцы таблицы и имя первичного ключа
+func getColumns(ctx context.Context, db *sql.DB, table string) ([]FieldInfo, string, error) {
 
-			err = rows.Scan(&col.Field, &col.Type, &col.Null, &col.Key, &col.Default, &col.Extra)
+	fieldInfo := []FieldInfo{}
 
-			if err != nil {
-				return nil, err
-			}
+	var nameID string
 
-			if col.Key == "PRI" {
-				isKey = true
-				nameID = col.Field
-			}
+	rows, err := db.QueryContext(ctx,
+		fmt.Sprintf(`SHOW COLUMNS FROM %s`, table),
+	)
 
-			if col.Null == "YES" {
-				null = true
-			}
+	if err != nil {
+		return nil, "", err
+	}
+
+	defer rows.Close()
+
+	col := Columns{}
 
-			fieldInfo = append(
-				fieldInfo,
-				FieldInfo{
-					Name:       col.Field,
-					ColumnType: col.Type,
-					IsKey:      isKey,
-					CouldNull:  null,
-				},
-			)
+	for rows.Next() {
+
+		var null bool
+		var isKey bool
+
+		err = rows.Scan(&col.Field, &col.Type, &col.Null, &col.Key, &col.Default, &col.Extra)
+
+		if err != nil {
+			return nil, "", err
 		}
 
-		tableInfo = append(
-			tableInfo,
-			TableInfo{
-				Name:   table,
-				ID:     nameID,
-				Fields: fieldInfo,
+		if col.Key == "PRI" {
+			isKey = true
+			nameID = col.Field
+		}
+
+		if col.Null == "YES" {
+			null = true
+		}
+
+		fieldInfo = append(
+			fieldInfo,
+			FieldInfo{
+				Name:       col.Field,
+				ColumnType: col.Type,
+				IsKey:      isKey,
+				CouldNull:  null,
 			},
 		)
 	}
 
-	log.Println(tableInfo)
-
-	return tableInfo, nil
+	return fieldInfo, nameID, rows.Err()
 }
 
 func URLLength(url string) int {
@@ -919,55 +1036,139 @@ func (h *Handler) mainHandler(w http.ResponseWriter, r *http.Request) {
 		switch lenurl {
 
 		case 0:
-			h.TableList(w)
+			h.TableList(w, r)
 		case 1:
 			h.SelectRecord(w, r)
 		case 2:
+			if strings.HasSuffix(url, "/_aggregate") {
+				h.Aggregate(w, r)
+				return
+			}
 			h.SelectRecordByID(w, r)
-		default:
-			w.WriteHeader(http.StatusBadGateway)
-			w.Header().Set("Content-Type", "application/json")
-			_, err := w.Write([]byte(`{"error" : "error way"}`))
-
-			if err != nil {
-				log.Println("Bad request:", err.Error())
+		case 3:
+			if strings.Split(strings.Trim(url, "/"), "/")[1] == "_distinct" {
+				h.Distinct(w, r)
+				return
 			}
+			writeError(w, r, http.StatusNotFound, CodeNotFound, fmt.Sprintf("no route for %s", url))
+		default:
+			writeError(w, r, http.StatusNotFound, CodeNotFound, fmt.Sprintf("no route for %s", url))
 		}
 
 	case "PUT":
 		h.CreateRecord(w, r)
 	case "POST":
+		if lenurl == 2 && strings.HasSuffix(url, "/_import") {
+			h.Import(w, r)
+			return
+		}
 		h.UpdateRecord(w, r)
 	case "DELETE":
 		h.DeleteRecord(w, r)
 	default:
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		w.Header().Set("Content-Type", "application/json")
-		_, err := w.Write([]byte(`{"error" : "error method"}`))
-
-		if err != nil {
-			log.Println("Bad request:", err.Error())
-		}
+		w.Header().Set("Allow", "GET, PUT, POST, DELETE")
+		writeError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed,
+			fmt.Sprintf("method %s is not allowed", r.Method))
 	}
 }
 
 func NewDBExplorer(db *sql.DB) (http.Handler, error) {
+	return NewDBExplorerWithConfig(db, DefaultConfig())
+}
+
+func NewDBExplorerWithConfig(db *sql.DB, cfg Config) (http.Handler, error) {
+
+	cfg = cfg.withDefaults()
+
+	if err := cfg.CORS.validate(); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Tables.validate(); err != nil {
+		return nil, err
+	}
+
+	cfg.Pool.Apply(db)
+
+	logger, err := NewLogger(cfg.Log, os.Stderr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	exporter, err := NewSpanExporter(cfg.Tracing)
+
+	if err != nil {
+		return nil, err
+	}
+
+	queries, err := compileQueries(cfg.Queries)
+
+	if err != nil {
+		return nil, err
+	}
 
 	handler := &Handler{
-		DB: db,
+		DB:           db,
+		Config:       cfg,
+		Metrics:      NewMetrics(db),
+		Logger:       logger,
+		SpanExporter: exporter,
+		queries:      queries,
+	}
+
+	audit, err := NewAuditSink(db, cfg.Audit)
+
+	if err != nil {
+		return nil, err
 	}
 
-	tableInfo, err := GetTablesInfo(db)
+	handler.Audit = audit
+
+	// фоновые задачи пишут в тот же лог, что и запросы
+	ctx, stop := context.WithCancel(context.WithValue(context.Background(), loggerKey, logger))
+
+	_, _, err = handler.ReloadSchema(ctx, true)
 
 	if err != nil {
+		stop()
 		return nil, err
 	}
 
-	handler.Table = tableInfo
+	if cfg.Schema.ReloadInterval > 0 || cfg.Schema.ChecksumInterval > 0 {
+		go handler.watchSchema(ctx)
+	}
 
 	mux := http.NewServeMux()
 
 	mux.HandleFunc("/", handler.mainHandler)
+	mux.HandleFunc("/_audit", handler.AuditLog)
+	mux.HandleFunc("/_stats", handler.Stats)
+	mux.HandleFunc("/_admin/reload", handler.AdminReload)
+	mux.HandleFunc("/_rpc/", handler.RPC)
+	mux.HandleFunc("/_sql", handler.AdHocSQL)
+	mux.HandleFunc("/_queries", handler.SavedQueries)
+	mux.HandleFunc("/_queries/", handler.SavedQueries)
+
+	// пробы не должны упираться в квоты клиентов
+	root := http.NewServeMux()
+
+	root.HandleFunc("/healthz", handler.Healthz)
+	root.HandleFunc("/readyz", handler.Readyz)
+	root.HandleFunc("/metrics", handler.MetricsHandler)
+	root.Handle("/", handler.instrument(cfg.CORS.Middleware(NewRateLimiter(cfg.RateLimit, cfg.Auth).Middleware(mux))))
+
+	return &explorer{Handler: handler.withRequestID(handler.trace(root)), stop: stop}, nil
+}
+
+// Хендлер эксплорера вместе с его фоновыми задачами
+type explorer struct {
+	http.Handler
+	stop context.CancelFunc
+}
 
-	return mux, nil
+// Останавливаем фоновое перечитывание схемы
+func (e *explorer) Close() error {
+	e.stop()
+	return nil
 }
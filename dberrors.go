@@ -0,0 +1,134 @@
+package dbexplorer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// Номера ошибок MySQL, которые показываем клиенту
+const (
+	mysqlErrDuplicateEntry  = 1062
+	mysqlErrRowIsReferenced = 1451
+	mysqlErrNoReferencedRow = 1452
+	mysqlErrDataTooLong     = 1406
+	mysqlErrBadNull         = 1048
+	mysqlErrOutOfRange      = 1264
+	mysqlErrIncorrectValue  = 1366
+	mysqlErrTruncatedValue  = 1292
+	mysqlErrLockWaitTimeout = 1205
+	mysqlErrDeadlock        = 1213
+)
+
+// Через сколько советуем клиенту повторить запрос после дедлока
+const lockRetryAfter = time.Second
+
+var (
+	reDuplicateEntry = regexp.MustCompile(`Duplicate entry '(.*)' for key '([^']*)'`)
+	reColumnName     = regexp.MustCompile(`(?i)column '([^']*)'`)
+)
+
+// Переводим ошибку драйвера MySQL в ответ клиенту. nil - ошибка не из тех,
+// о которых стоит рассказывать, отвечаем 500
+func dbProblem(err error) *Problem {
+	return tableProblem(TableInfo{}, err)
+}
+
+// То же для запроса к таблице: столбец из ошибки называем публичным именем
+func tableProblem(table TableInfo, err error) *Problem {
+
+	var me *mysql.MySQLError
+
+	if !errors.As(err, &me) {
+		return nil
+	}
+
+	switch me.Number {
+
+	case mysqlErrDuplicateEntry:
+
+		p := NewProblem(http.StatusConflict, CodeDuplicate, "record with this key already exists")
+
+		if m := reDuplicateEntry.FindStringSubmatch(me.Message); m != nil {
+			// в MySQL 8 имя ключа идет с префиксом таблицы: items.PRIMARY
+			key := m[2][strings.LastIndex(m[2], ".")+1:]
+			p.Field = key
+			p.Detail = fmt.Sprintf("duplicate value '%s' for key %s", m[1], key)
+		}
+
+		return p
+
+	case mysqlErrRowIsReferenced:
+		return NewProblem(http.StatusConflict, CodeReferenced,
+			"record is referenced by other records")
+
+	case mysqlErrNoReferencedRow:
+		return NewProblem(http.StatusUnprocessableEntity, CodeForeignKey,
+			"referenced record does not exist")
+
+	case mysqlErrDataTooLong:
+		return columnProblem(me, table, CodeDataTooLong, "value is too long for field %s")
+
+	case mysqlErrBadNull:
+		return columnProblem(me, table, CodeNotNull, "field %s can not be null")
+
+	case mysqlErrOutOfRange, mysqlErrIncorrectValue, mysqlErrTruncatedValue:
+		return columnProblem(me, table, CodeInvalidValue, "invalid value for field %s")
+
+	case mysqlErrLockWaitTimeout, mysqlErrDeadlock:
+		p := NewProblem(http.StatusServiceUnavailable, CodeLockConflict,
+			"record is locked by a concurrent request, retry later")
+		p.RetryAfter = lockRetryAfter
+		return p
+	}
+
+	return nil
+}
+
+// Ошибка значения конкретного столбца. Имя столбца достаем из текста ошибки MySQL
+// и переводим в публичное через поля таблицы
+func columnProblem(me *mysql.MySQLError, table TableInfo, code, format string) *Problem {
+
+	column := ""
+
+	if m := reColumnName.FindStringSubmatch(me.Message); m != nil {
+		column = m[1]
+	}
+
+	if field, ok := fieldByColumn(table, column); ok {
+		column = field.Name
+	}
+
+	return FieldProblem(http.StatusUnprocessableEntity, code, column, fmt.Sprintf(format, column))
+}
+
+// Ответ на ошибку запроса к БД: таймаут, уход клиента, понятная ошибка MySQL или 500.
+// Логируем с переданными атрибутами
+func (h *Handler) dbError(ctx context.Context, w http.ResponseWriter, r *http.Request, err error, msg string, attrs ...interface{}) {
+	h.tableError(ctx, w, r, TableInfo{}, err, msg, attrs...)
+}
+
+// То же для записи в таблицу: в ответе публичные имена столбцов
+func (h *Handler) tableError(ctx context.Context, w http.ResponseWriter, r *http.Request, table TableInfo, err error, msg string, attrs ...interface{}) {
+
+	if h.contextError(ctx, w, r, err) {
+		return
+	}
+
+	attrs = append(attrs, "error", err)
+
+	if p := tableProblem(table, err); p != nil {
+		requestLogger(r).Warn(msg, attrs...)
+		writeProblem(w, r, p)
+		return
+	}
+
+	requestLogger(r).Error(msg, attrs...)
+	writeInternalError(w, r)
+}
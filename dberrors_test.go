@@ -0,0 +1,70 @@
+package dbexplorer
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestDBProblem(t *testing.T) {
+
+	cases := []struct {
+		err    error
+		status int
+		code   string
+		field  string
+	}{
+		{&mysql.MySQLError{Number: 1062, Message: "Duplicate entry 'rvasily' for key 'users.login'"},
+			http.StatusConflict, CodeDuplicate, "login"},
+		{&mysql.MySQLError{Number: 1062, Message: "Duplicate entry '1' for key 'PRIMARY'"},
+			http.StatusConflict, CodeDuplicate, "PRIMARY"},
+		{&mysql.MySQLError{Number: 1451, Message: "Cannot delete or update a parent row"},
+			http.StatusConflict, CodeReferenced, ""},
+		{&mysql.MySQLError{Number: 1452, Message: "Cannot add or update a child row"},
+			http.StatusUnprocessableEntity, CodeForeignKey, ""},
+		{&mysql.MySQLError{Number: 1406, Message: "Data too long for column 'title' at row 1"},
+			http.StatusUnprocessableEntity, CodeDataTooLong, "title"},
+		{&mysql.MySQLError{Number: 1292, Message: "Incorrect datetime value: 'soon' for column 'created' at row 3"},
+			http.StatusUnprocessableEntity, CodeInvalidValue, "created"},
+		{fmt.Errorf("exec: %w", &mysql.MySQLError{Number: 1213, Message: "Deadlock found"}),
+			http.StatusServiceUnavailable, CodeLockConflict, ""},
+	}
+
+	for _, c := range cases {
+
+		p := dbProblem(c.err)
+
+		if p == nil || p.Status != c.status || p.Code != c.code || p.Field != c.field {
+			t.Errorf("%v: got %+v", c.err, p)
+		}
+	}
+
+	if p := dbProblem(&mysql.MySQLError{Number: 1205}); p.RetryAfter == 0 {
+		t.Error("lock wait timeout without retry hint")
+	}
+
+	if p := dbProblem(errors.New("driver: bad connection")); p != nil {
+		t.Errorf("unexpected problem for plain error: %+v", p)
+	}
+}
+
+func TestTableProblem(t *testing.T) {
+
+	table := TableInfo{Name: "people", DBName: "tbl_usr", Fields: []FieldInfo{
+		{Name: "id", DBName: "usr_id", IsKey: true}, {Name: "name", DBName: "usr_nm"}}}
+
+	p := tableProblem(table, &mysql.MySQLError{Number: 1406, Message: "Data too long for column 'usr_nm' at row 1"})
+
+	if p == nil || p.Field != "name" || p.Detail != "value is too long for field name" {
+		t.Errorf("got %+v", p)
+	}
+
+	p = tableProblem(table, &mysql.MySQLError{Number: 1048, Message: "Column 'other' cannot be null"})
+
+	if p == nil || p.Field != "other" {
+		t.Errorf("unknown column: got %+v", p)
+	}
+}
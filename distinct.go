@@ -0,0 +1,112 @@
+package dbexplorer
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Параметры /_distinct, которые не являются фильтрами по столбцам
+var distinctParams = map[string]bool{
+	"limit":  true,
+	"offset": true,
+}
+
+// Хендлер для различных значений столбца с числом записей, самые частые первыми.
+// Фильтры как у списка. Вызывается по эндпоинту "/{table}/_distinct/{column}" [GET]
+func (h *Handler) Distinct(w http.ResponseWriter, r *http.Request) {
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	table, column := parts[0], parts[2]
+
+	tables := h.tables()
+
+	cond, idx, _ := contains(tables, table)
+
+	if !cond {
+		writeError(w, r, http.StatusNotFound, CodeUnknownTable, fmt.Sprintf("unknown table %s", table))
+		return
+	}
+
+	field, ok := fieldByName(tables[idx], column)
+
+	if !ok {
+		writeError(w, r, http.StatusNotFound, CodeUnknownField, fmt.Sprintf("unknown field %s", column))
+		return
+	}
+
+	// у свободного текста значений почти столько же, сколько записей. Пустой или кривой
+	// limit pageParams заменил бы лимитом по умолчанию, поэтому проверяем сами
+	if limit, err := strconv.Atoi(r.URL.Query().Get("limit")); isTextType(field.ColumnType) && (err != nil || limit <= 0) {
+		writeProblem(w, r, FieldProblem(http.StatusBadRequest, CodeInvalidParam, "limit",
+			fmt.Sprintf("field %s is %s and may have too many values: limit is required", column, field.ColumnType)))
+		return
+	}
+
+	lim, off, err := h.pageParams(table, r)
+
+	if err != nil {
+		writeProblem(w, r, pageProblem(err))
+		return
+	}
+
+	where, args, err := filterParams(tables[idx], r, distinctParams, h.Config.Limits.StrictFilters)
+
+	if err != nil {
+		writeProblem(w, r, err.(*Problem))
+		return
+	}
+
+	if where != "" {
+		where = " WHERE " + where
+	}
+
+	query := fmt.Sprintf(
+		"SELECT %s AS `value`, COUNT(*) AS `count` FROM %s%s GROUP BY %s ORDER BY `count` DESC, %s LIMIT %d, %d",
+		field.Column(), tables[idx].Table(), where, field.Column(), field.Column(), off, lim,
+	)
+
+	ctx, cancel := h.operationContext(r.Context(), "distinct")
+	defer cancel()
+
+	err = h.checkQueryCost(ctx, table, query, args...)
+
+	if err == ErrFullScan {
+		writeError(w, r, http.StatusBadRequest, CodeFullScan, err.Error())
+		return
+	}
+
+	if err != nil && h.contextError(ctx, w, r, err) {
+		return
+	}
+
+	if err != nil {
+		requestLogger(r).Warn("bad explain of query", "table", table, "error", err)
+	}
+
+	rows, err := h.query(ctx, table, "distinct", query, args...)
+
+	if err != nil {
+		h.dbError(ctx, w, r, err, "bad distinct query", "table", table, "field", column)
+		return
+	}
+
+	defer rows.Close()
+
+	values, err := scanAll(rows)
+
+	if err != nil {
+		h.dbError(ctx, w, r, err, "bad distinct query", "table", table, "field", column)
+		return
+	}
+
+	h.rowsReturned(r.Context(), table, "distinct", len(values))
+
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"response": map[string]interface{}{
+			"field":  column,
+			"values": values,
+		},
+	})
+}
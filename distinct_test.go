@@ -0,0 +1,44 @@
+package dbexplorer
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDistinctValidation(t *testing.T) {
+
+	h := &Handler{Config: DefaultConfig().withDefaults()}
+	h.schema.Store(&Schema{Tables: []TableInfo{{Name: "items", ID: "id", Fields: []FieldInfo{
+		{Name: "id", ColumnType: TypeInt, IsKey: true},
+		{Name: "description", ColumnType: TypeText},
+	}}}})
+
+	cases := []struct {
+		url    string
+		status int
+		code   string
+	}{
+		{"/users/_distinct/id", http.StatusNotFound, CodeUnknownTable},
+		{"/items/_distinct/password", http.StatusNotFound, CodeUnknownField},
+		{"/items/_distinct/description", http.StatusBadRequest, CodeInvalidParam},
+		{"/items/_distinct/description?limit=-1", http.StatusBadRequest, CodeInvalidParam},
+		{"/items/_distinct/description?limit=", http.StatusBadRequest, CodeInvalidParam},
+		{"/items/_distinct/description?limit=abc", http.StatusBadRequest, CodeInvalidParam},
+		{"/items/_distinct/description?limit=0", http.StatusBadRequest, CodeInvalidParam},
+	}
+
+	for _, c := range cases {
+
+		w := httptest.NewRecorder()
+
+		h.Distinct(w, httptest.NewRequest(http.MethodGet, c.url, nil))
+
+		var p Problem
+
+		if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil || w.Code != c.status || p.Code != c.code {
+			t.Errorf("%s: got %d %s, want %d %s", c.url, w.Code, w.Body, c.status, c.code)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package dbexplorer
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Машиночитаемые коды ошибок API
+const (
+	CodeUnknownTable     = "unknown_table"
+	CodeReadOnlyTable    = "read_only_table"
+	CodeNoKey            = "no_key"
+	CodeUnknownRoutine   = "unknown_routine"
+	CodeNotReadOnly      = "not_read_only"
+	CodeUnauthorized     = "unauthorized"
+	CodeForbidden        = "forbidden"
+	CodeNotFound         = "not_found"
+	CodeInvalidID        = "invalid_id"
+	CodeInvalidBody      = "invalid_body"
+	CodeUnsupportedMedia = "unsupported_media_type"
+	CodeInvalidType      = "invalid_type"
+	CodeNotNull          = "not_null"
+	CodeReadOnlyField    = "read_only_field"
+	CodeUnknownField     = "unknown_field"
+	CodeInvalidParam     = "invalid_param"
+	CodeFullScan         = "full_scan"
+	CodeMethodNotAllowed = "method_not_allowed"
+	CodeRateLimited      = "rate_limited"
+	CodeTimeout          = "timeout"
+	CodeUnavailable      = "unavailable"
+	CodeDuplicate        = "duplicate"
+	CodeReferenced       = "referenced"
+	CodeForeignKey       = "foreign_key"
+	CodeDataTooLong      = "data_too_long"
+	CodeInvalidValue     = "invalid_value"
+	CodeLockConflict     = "lock_conflict"
+	CodeNotImplemented   = "not_implemented"
+	CodeInternal         = "internal"
+)
+
+// Тип ответа с ошибкой по RFC 7807
+const ProblemContentType = "application/problem+json"
+
+// Ошибка API в формате RFC 7807. Все хендлеры отвечают ошибками только через нее
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	// Машиночитаемый код, см. Code*
+	Code string `json:"code"`
+	// Поле запроса, к которому относится ошибка валидации
+	Field     string `json:"field,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+	// Через сколько клиенту стоит повторить запрос. Уходит в заголовок Retry-After
+	RetryAfter time.Duration `json:"-"`
+}
+
+func NewProblem(status int, code, detail string) *Problem {
+	return &Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Code:   code,
+	}
+}
+
+// Ошибка валидации конкретного поля
+func FieldProblem(status int, code, field, detail string) *Problem {
+
+	p := NewProblem(status, code, detail)
+	p.Field = field
+
+	return p
+}
+
+func (p *Problem) Error() string {
+
+	if p.Field != "" {
+		return fmt.Sprintf("%s: %s", p.Field, p.Detail)
+	}
+
+	return p.Detail
+}
+
+// Отдаем ошибку клиенту как application/problem+json
+func writeProblem(w http.ResponseWriter, r *http.Request, p *Problem) {
+
+	body := *p
+	body.RequestID = RequestIDFromContext(r.Context())
+
+	result, err := json.Marshal(body)
+
+	if err != nil {
+		requestLogger(r).Error("bad packed json", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if p.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(p.RetryAfter.Seconds()))))
+	}
+
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.WriteHeader(p.Status)
+
+	_, err = w.Write(result)
+
+	if err != nil {
+		requestLogger(r).Warn("bad write of response", "error", err)
+	}
+}
+
+// Короткая запись для ошибки без поля
+func writeError(w http.ResponseWriter, r *http.Request, status int, code, detail string) {
+	writeProblem(w, r, NewProblem(status, code, detail))
+}
+
+// Внутренняя ошибка. Подробности только в логе, клиенту - идентификатор запроса
+func writeInternalError(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, http.StatusInternalServerError, CodeInternal, "internal error")
+}
@@ -0,0 +1,77 @@
+package dbexplorer
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWriteProblem(t *testing.T) {
+
+	r := httptest.NewRequest("POST", "/items/1", nil)
+	r = r.WithContext(context.WithValue(r.Context(), requestIDKey, "abc"))
+	w := httptest.NewRecorder()
+
+	writeProblem(w, r, FieldProblem(http.StatusBadRequest, CodeInvalidType, "title", "bad title"))
+
+	if w.Code != http.StatusBadRequest || w.Header().Get("Content-Type") != ProblemContentType {
+		t.Fatalf("got status %d, content type %q", w.Code, w.Header().Get("Content-Type"))
+	}
+
+	var got Problem
+
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+
+	want := Problem{Type: "about:blank", Title: "Bad Request", Status: 400, Detail: "bad title",
+		Code: CodeInvalidType, Field: "title", RequestID: "abc"}
+
+	if got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestCheckParamsProblems(t *testing.T) {
+
+	table := TableInfo{Name: "items", ID: "id", Fields: []FieldInfo{
+		{Name: "id", ColumnType: TypeInt, IsKey: true},
+		{Name: "title", ColumnType: TypeVarchar},
+		{Name: "updated", ColumnType: TypeVarchar, CouldNull: true},
+	}}
+
+	cases := []struct {
+		body  string
+		code  string
+		field string
+	}{
+		{`{"id": 4}`, CodeReadOnlyField, "id"},
+		{`{"title": 42}`, CodeInvalidType, "title"},
+		{`{"title": null}`, CodeNotNull, "title"},
+		{`{"title": ["a"]}`, CodeInvalidType, "title"},
+		{`{"nope": 1}`, CodeUnknownField, "nope"},
+		{`[1]`, CodeInvalidBody, ""},
+	}
+
+	for _, c := range cases {
+
+		r := httptest.NewRequest("POST", "/items/1", strings.NewReader(c.body))
+
+		_, _, _, err := CheckParamsAndTypes(table, r)
+
+		p, ok := err.(*Problem)
+
+		if !ok || p.Code != c.code || p.Field != c.field {
+			t.Errorf("%s: got %v, want %s on %q", c.body, err, c.code, c.field)
+		}
+	}
+
+	r := httptest.NewRequest("POST", "/items/1", strings.NewReader(`{"updated": null}`))
+
+	if _, placeholder, _, err := CheckParamsAndTypes(table, r); err != nil || placeholder != "updated = ?" {
+		t.Errorf("valid update: got %q, %v", placeholder, err)
+	}
+}
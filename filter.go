@@ -0,0 +1,89 @@
+package dbexplorer
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Параметры списка, которые не являются фильтрами по столбцам
+var listParams = map[string]bool{
+	"limit":    true,
+	"offset":   true,
+	"q":        true,
+	"snippets": true,
+	"format":   true,
+	"null":     true,
+}
+
+// Условие WHERE из параметров запроса вида ?column=value. Несколько значений
+// одного столбца (?id=1&id=2) превращаются в IN. Имена столбцов - публичные,
+// параметры из reserved фильтрами не считаются. Параметры, которые не поля таблицы,
+// пропускаем, а при strict отвечаем на них 400. Пустая строка - фильтров нет
+func filterParams(table TableInfo, r *http.Request, reserved map[string]bool, strict bool) (string, []interface{}, error) {
+
+	query := r.URL.Query()
+
+	names := make([]string, 0, len(query))
+
+	for name := range query {
+		if !reserved[name] {
+			names = append(names, name)
+		}
+	}
+
+	// одинаковые запросы - одинаковый SQL, это нужно для логов и EXPLAIN
+	sort.Strings(names)
+
+	where := make([]string, 0, len(names))
+	args := make([]interface{}, 0, len(names))
+
+	for _, name := range names {
+
+		field, ok := fieldByName(table, name)
+
+		if !ok && !strict {
+			continue
+		}
+
+		if !ok {
+			return "", nil, FieldProblem(http.StatusBadRequest, CodeUnknownField, name,
+				fmt.Sprintf("can not filter by %s: no such field", name))
+		}
+
+		values := query[name]
+
+		for _, value := range values {
+
+			if field.ColumnType != TypeInt {
+				args = append(args, value)
+				continue
+			}
+
+			n, err := strconv.ParseInt(value, 10, 64)
+
+			if err != nil {
+				return "", nil, FieldProblem(http.StatusBadRequest, CodeInvalidType, name,
+					fmt.Sprintf("filter %s must be an integer", name))
+			}
+
+			args = append(args, n)
+		}
+
+		if len(values) == 1 {
+			where = append(where, fmt.Sprintf("%s = ?", field.Column()))
+			continue
+		}
+
+		where = append(where, fmt.Sprintf("%s IN (%s)", field.Column(),
+			strings.TrimSuffix(strings.Repeat("?,", len(values)), ",")))
+	}
+
+	if len(where) == 0 {
+		return "", nil, nil
+	}
+
+	return strings.Join(where, " AND "), args, nil
+}
@@ -0,0 +1,46 @@
+package dbexplorer
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestFilterParams(t *testing.T) {
+
+	table := TableInfo{Name: "people", DBName: "tbl_usr", ID: "usr_id", Fields: []FieldInfo{
+		{Name: "usr_id", ColumnType: TypeInt, IsKey: true},
+		{Name: "name", DBName: "usr_nm", ColumnType: TypeVarchar},
+	}}
+
+	cases := []struct {
+		query  string
+		strict bool
+		where  string
+		args   []interface{}
+		code   string
+	}{
+		{"limit=5&offset=1", true, "", nil, ""},
+		{"name=bob&usr_id=1&usr_id=2", true, "usr_nm = ? AND usr_id IN (?,?)", []interface{}{"bob", int64(1), int64(2)}, ""},
+		{"usr_id=x", false, "", nil, CodeInvalidType},
+		{"password=1", true, "", nil, CodeUnknownField},
+		// без strict лишние параметры вроде ?_=123 и utm_* не мешают
+		{"_=123&utm_source=mail&name=bob", false, "usr_nm = ?", []interface{}{"bob"}, ""},
+	}
+
+	for _, c := range cases {
+
+		where, args, err := filterParams(table, httptest.NewRequest("GET", "/people?"+c.query, nil), listParams, c.strict)
+
+		if c.code != "" {
+			if p, ok := err.(*Problem); !ok || p.Code != c.code {
+				t.Errorf("%s: got error %v, want %s", c.query, err, c.code)
+			}
+			continue
+		}
+
+		if err != nil || where != c.where || !reflect.DeepEqual(args, c.args) {
+			t.Errorf("%s: got %q %v %v", c.query, where, args, err)
+		}
+	}
+}
@@ -0,0 +1,8 @@
+module github.com/d3vyatk4ru/db-go-conn-service
+
+go 1.21
+
+require (
+	github.com/go-sql-driver/mysql v1.7.1
+	gopkg.in/yaml.v3 v3.0.1
+)
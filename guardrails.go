@@ -0,0 +1,215 @@
+package dbexplorer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Ограничения выборки для отдельной таблицы. Нулевые поля берутся из общих настроек
+type TableQueryLimits struct {
+	MaxLimit  int `yaml:"max_limit"`
+	MaxOffset int `yaml:"max_offset"`
+	// Большая таблица: при включенном Explain запросы с полным сканированием отклоняются
+	Large bool `yaml:"large"`
+}
+
+// Ограничения на запросы выборки
+type QueryLimitsConfig struct {
+	// Лимит по умолчанию и максимальный лимит. Больший limit урезается до MaxLimit
+	DefaultLimit int `yaml:"default_limit"`
+	MaxLimit     int `yaml:"max_limit"`
+	// Максимальный offset. 0 - без ограничения
+	MaxOffset int `yaml:"max_offset"`
+	// Таймаут одного SQL-запроса. 0 - без таймаута
+	StatementTimeout time.Duration `yaml:"statement_timeout"`
+	// Таймауты по операциям: list, get, create, update, delete. Заменяют StatementTimeout
+	Timeouts map[string]time.Duration `yaml:"timeouts"`
+	// Проверять план запроса через EXPLAIN для таблиц с Large = true
+	Explain bool                        `yaml:"explain"`
+	Tables  map[string]TableQueryLimits `yaml:"tables"`
+	// Параметр списка, который не поле таблицы, - ошибка 400. По умолчанию такие
+	// параметры (?_=123, utm_*) пропускаем, как и раньше
+	StrictFilters bool `yaml:"strict_filters"`
+}
+
+// Ошибка в параметрах выборки, клиенту отдаем 400
+type PageError struct {
+	Param   string
+	Message string
+}
+
+func (e *PageError) Error() string {
+	return fmt.Sprintf("%s %s", e.Param, e.Message)
+}
+
+// Ошибка параметров страницы для клиента
+func pageProblem(err error) *Problem {
+
+	if pe, ok := err.(*PageError); ok {
+		return FieldProblem(http.StatusBadRequest, CodeInvalidParam, pe.Param, pe.Error())
+	}
+
+	return NewProblem(http.StatusBadRequest, CodeInvalidParam, err.Error())
+}
+
+// Ограничения, которые действуют для таблицы
+func (c QueryLimitsConfig) forTable(table string) TableQueryLimits {
+
+	limits := c.Tables[table]
+
+	if limits.MaxLimit == 0 {
+		limits.MaxLimit = c.MaxLimit
+	}
+
+	if limits.MaxOffset == 0 {
+		limits.MaxOffset = c.MaxOffset
+	}
+
+	return limits
+}
+
+// Разбираем limit и offset из запроса. Нечисловые значения заменяем значениями по умолчанию,
+// отрицательные и слишком большие смещения отклоняем
+func (h *Handler) pageParams(table string, r *http.Request) (int, int, error) {
+
+	cfg := h.Config.Limits
+	limits := cfg.forTable(table)
+
+	lim, err := strconv.Atoi(r.URL.Query().Get("limit"))
+
+	if err != nil {
+		lim = cfg.DefaultLimit
+	}
+
+	if lim < 0 {
+		return 0, 0, &PageError{"limit", "must not be negative"}
+	}
+
+	if limits.MaxLimit > 0 && lim > limits.MaxLimit {
+		lim = limits.MaxLimit
+	}
+
+	off, err := strconv.Atoi(r.URL.Query().Get("offset"))
+
+	if err != nil {
+		off = 0
+	}
+
+	if off < 0 {
+		return 0, 0, &PageError{"offset", "must not be negative"}
+	}
+
+	if limits.MaxOffset > 0 && off > limits.MaxOffset {
+		return 0, 0, &PageError{"offset", fmt.Sprintf("must not exceed %d", limits.MaxOffset)}
+	}
+
+	return lim, off, nil
+}
+
+// Контекст запроса с таймаутом операции из настроек
+func (h *Handler) operationContext(ctx context.Context, operation string) (context.Context, context.CancelFunc) {
+
+	timeout, ok := h.Config.Limits.Timeouts[operation]
+
+	if !ok {
+		timeout = h.Config.Limits.StatementTimeout
+	}
+
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
+
+// Статус для клиента, который закрыл соединение, не дождавшись ответа. Так делает nginx
+const StatusClientClosedRequest = 499
+
+// Если запрос к БД прервал контекст, отвечаем 504 по таймауту или 499, если клиент ушел.
+// Возвращает false, если ошибка с контекстом не связана
+func (h *Handler) contextError(ctx context.Context, w http.ResponseWriter, r *http.Request, err error) bool {
+
+	switch {
+	case errors.Is(r.Context().Err(), context.Canceled):
+		requestLogger(r).Info("client closed request", "status", StatusClientClosedRequest, "error", err)
+		w.WriteHeader(StatusClientClosedRequest)
+		return true
+
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		requestLogger(r).Warn("statement timeout", "error", err)
+		writeError(w, r, http.StatusGatewayTimeout, CodeTimeout, "query timeout")
+		return true
+	}
+
+	return false
+}
+
+// Запрос требует полного сканирования большой таблицы
+var ErrFullScan = fmt.Errorf("query requires a full table scan")
+
+// Проверяем план запроса к большой таблице. Если MySQL собирается читать таблицу
+// целиком (type = ALL), возвращаем ErrFullScan
+func (h *Handler) checkQueryCost(ctx context.Context, table, query string, args ...interface{}) error {
+
+	if !h.Config.Limits.Explain || !h.Config.Limits.forTable(table).Large {
+		return nil
+	}
+
+	rows, err := h.query(ctx, table, "explain", "EXPLAIN "+query, args...)
+
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+
+	if err != nil {
+		return err
+	}
+
+	typeIdx := -1
+
+	for i, column := range columns {
+		if strings.EqualFold(column, "type") {
+			typeIdx = i
+		}
+	}
+
+	if typeIdx < 0 {
+		return nil
+	}
+
+	for rows.Next() {
+
+		values := make([]interface{}, len(columns))
+
+		for i := range values {
+			values[i] = new(interface{})
+		}
+
+		err = rows.Scan(values...)
+
+		if err != nil {
+			return err
+		}
+
+		access := *(values[typeIdx].(*interface{}))
+
+		if b, ok := access.([]byte); ok && strings.EqualFold(string(b), "ALL") {
+			return ErrFullScan
+		}
+
+		if s, ok := access.(string); ok && strings.EqualFold(s, "ALL") {
+			return ErrFullScan
+		}
+	}
+
+	return rows.Err()
+}
@@ -0,0 +1,115 @@
+package dbexplorer
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPageParams(t *testing.T) {
+
+	h := &Handler{Config: Config{Limits: QueryLimitsConfig{
+		MaxOffset: 100,
+		Tables: map[string]TableQueryLimits{
+			"items": {MaxLimit: 10},
+		},
+	}}.withDefaults()}
+
+	cases := []struct {
+		table string
+		query string
+		lim   int
+		off   int
+		fail  bool
+	}{
+		{"users", "", 5, 0, false},
+		{"users", "limit=1'&offset=1\"", 5, 0, false},
+		{"users", "limit=5000", 1000, 0, false},
+		{"items", "limit=50&offset=3", 10, 3, false},
+		{"items", "limit=-1", 0, 0, true},
+		{"items", "offset=-1", 0, 0, true},
+		{"items", "offset=101", 0, 0, true},
+	}
+
+	for _, c := range cases {
+
+		lim, off, err := h.pageParams(c.table, httptest.NewRequest("GET", "/"+c.table+"?"+c.query, nil))
+
+		if c.fail {
+			if _, ok := err.(*PageError); !ok {
+				t.Errorf("%s?%s: expected PageError, got %v", c.table, c.query, err)
+			}
+			continue
+		}
+
+		if err != nil || lim != c.lim || off != c.off {
+			t.Errorf("%s?%s: got %d, %d, %v; want %d, %d", c.table, c.query, lim, off, err, c.lim, c.off)
+		}
+	}
+}
+
+func TestOperationContext(t *testing.T) {
+
+	h := &Handler{Config: Config{Limits: QueryLimitsConfig{
+		StatementTimeout: time.Minute,
+		Timeouts:         map[string]time.Duration{"get": time.Second, "delete": 0},
+	}}}
+
+	cases := []struct {
+		operation string
+		timeout   time.Duration
+	}{
+		{"list", time.Minute},
+		{"get", time.Second},
+		{"delete", 0},
+	}
+
+	for _, c := range cases {
+
+		ctx, cancel := h.operationContext(context.Background(), c.operation)
+		deadline, ok := ctx.Deadline()
+		cancel()
+
+		if c.timeout == 0 && ok {
+			t.Errorf("%s: unexpected deadline", c.operation)
+		}
+
+		if c.timeout > 0 && (!ok || time.Until(deadline) > c.timeout) {
+			t.Errorf("%s: deadline %v, want within %v", c.operation, deadline, c.timeout)
+		}
+	}
+}
+
+func TestContextError(t *testing.T) {
+
+	h := &Handler{}
+
+	expired, cancel := context.WithTimeout(context.Background(), 0)
+	defer cancel()
+
+	w := httptest.NewRecorder()
+
+	if !h.contextError(expired, w, httptest.NewRequest("GET", "/items", nil), expired.Err()) ||
+		w.Code != http.StatusGatewayTimeout {
+		t.Errorf("timeout: got status %d, want 504", w.Code)
+	}
+
+	gone, stop := context.WithCancel(context.Background())
+	stop()
+
+	w = httptest.NewRecorder()
+
+	if !h.contextError(gone, w, httptest.NewRequest("GET", "/items", nil).WithContext(gone), gone.Err()) ||
+		w.Code != StatusClientClosedRequest {
+		t.Errorf("canceled: got status %d, want 499", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+
+	if h.contextError(context.Background(), w, httptest.NewRequest("GET", "/items", nil), errors.New("boom")) {
+		t.Error("plain error handled as context error")
+	}
+}
@@ -0,0 +1,101 @@
+package dbexplorer
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Настройки пула соединений. Нулевые значения не меняют настройки database/sql
+type PoolConfig struct {
+	MaxOpenConns    int           `yaml:"max_open_conns"`
+	MaxIdleConns    int           `yaml:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime"`
+	ConnMaxIdleTime time.Duration `yaml:"conn_max_idle_time"`
+}
+
+// Применяем настройки пула к подключению
+func (c PoolConfig) Apply(db *sql.DB) {
+
+	if c.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(c.MaxOpenConns)
+	}
+
+	if c.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(c.MaxIdleConns)
+	}
+
+	if c.ConnMaxLifetime > 0 {
+		db.SetConnMaxLifetime(c.ConnMaxLifetime)
+	}
+
+	if c.ConnMaxIdleTime > 0 {
+		db.SetConnMaxIdleTime(c.ConnMaxIdleTime)
+	}
+}
+
+// Хендлер проверки живости процесса. БД не трогает. Вызывается по эндпоинту "/healthz" [GET]
+func (h *Handler) Healthz(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed,
+			fmt.Sprintf("method %s is not allowed", r.Method))
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// Хендлер проверки готовности: пингуем БД с таймаутом. Вызывается по эндпоинту "/readyz" [GET]
+func (h *Handler) Readyz(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed,
+			fmt.Sprintf("method %s is not allowed", r.Method))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), h.Config.Health.PingTimeout)
+	defer cancel()
+
+	err := h.DB.PingContext(ctx)
+
+	if err != nil {
+		requestLogger(r).Warn("database ping failed", "error", err)
+		writeError(w, r, http.StatusServiceUnavailable, CodeUnavailable, "database is unavailable")
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// Хендлер статистики пула соединений. Вызывается по эндпоинту "/_stats" [GET]
+func (h *Handler) Stats(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed,
+			fmt.Sprintf("method %s is not allowed", r.Method))
+		return
+	}
+
+	stats := h.DB.Stats()
+
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"response": map[string]interface{}{
+			"max_open_connections":  stats.MaxOpenConnections,
+			"open_connections":      stats.OpenConnections,
+			"in_use":                stats.InUse,
+			"idle":                  stats.Idle,
+			"wait_count":            stats.WaitCount,
+			"wait_duration_seconds": stats.WaitDuration.Seconds(),
+			"max_idle_closed":       stats.MaxIdleClosed,
+			"max_idle_time_closed":  stats.MaxIdleTimeClosed,
+			"max_lifetime_closed":   stats.MaxLifetimeClosed,
+		},
+	})
+}
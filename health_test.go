@@ -0,0 +1,100 @@
+package dbexplorer
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+)
+
+// sql.Open не подключается к БД, поэтому пул годится для тестов без MySQL.
+// На порту 1 никто не слушает: пинг сразу получает отказ
+func unreachableDB(t *testing.T) *sql.DB {
+
+	db, err := sql.Open("mysql", "root:1234@tcp(127.0.0.1:1)/golang?timeout=1s")
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+func TestHealthHandlers(t *testing.T) {
+
+	h := &Handler{DB: unreachableDB(t), Config: DefaultConfig().withDefaults()}
+
+	cases := []struct {
+		handler http.HandlerFunc
+		method  string
+		status  int
+	}{
+		{h.Healthz, http.MethodGet, http.StatusOK},
+		{h.Healthz, http.MethodPost, http.StatusMethodNotAllowed},
+		{h.Readyz, http.MethodGet, http.StatusServiceUnavailable},
+		{h.Readyz, http.MethodDelete, http.StatusMethodNotAllowed},
+		{h.Stats, http.MethodGet, http.StatusOK},
+		{h.Stats, http.MethodPost, http.StatusMethodNotAllowed},
+	}
+
+	for i, c := range cases {
+
+		w := httptest.NewRecorder()
+		c.handler(w, httptest.NewRequest(c.method, "/", nil))
+
+		if w.Code != c.status {
+			t.Errorf("case %d: %s got status %d, want %d", i, c.method, w.Code, c.status)
+		}
+
+		if c.status == http.StatusMethodNotAllowed && w.Header().Get("Allow") != http.MethodGet {
+			t.Errorf("case %d: Allow is %q", i, w.Header().Get("Allow"))
+		}
+	}
+}
+
+func TestStatsResponse(t *testing.T) {
+
+	db := unreachableDB(t)
+
+	PoolConfig{MaxOpenConns: 7}.Apply(db)
+
+	h := &Handler{DB: db}
+
+	w := httptest.NewRecorder()
+	h.Stats(w, httptest.NewRequest(http.MethodGet, "/_stats", nil))
+
+	var body struct {
+		Response map[string]float64 `json:"response"`
+	}
+
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("bad json %q: %v", w.Body.String(), err)
+	}
+
+	if body.Response["max_open_connections"] != 7 || body.Response["open_connections"] != 0 {
+		t.Fatalf("unexpected stats %v", body.Response)
+	}
+}
+
+func TestPoolConfigApply(t *testing.T) {
+
+	db := unreachableDB(t)
+
+	PoolConfig{MaxOpenConns: 3, ConnMaxLifetime: time.Minute}.Apply(db)
+
+	if got := db.Stats().MaxOpenConnections; got != 3 {
+		t.Fatalf("max open conns: got %d, want 3", got)
+	}
+
+	// нулевые значения настройки пула не трогают
+	PoolConfig{}.Apply(db)
+
+	if got := db.Stats().MaxOpenConnections; got != 3 {
+		t.Fatalf("zero config must keep max open conns, got %d", got)
+	}
+}
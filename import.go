@@ -0,0 +1,862 @@
+package dbexplorer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Режимы импорта
+const (
+	// Только новые записи, дубликаты ключа - ошибки строк
+	ImportInsert = "insert"
+	// Новые записи добавляем, существующие обновляем
+	ImportUpsert = "upsert"
+	// Таблицу очищаем и заполняем заново в одной транзакции
+	ImportReplace = "replace"
+)
+
+// Больше плейсхолдеров в одном запросе MySQL не принимает
+const maxPlaceholders = 65535
+
+// Настройки импорта через /{table}/_import
+type ImportConfig struct {
+	// Сколько строк фиксируем одной транзакцией. В режиме replace транзакция одна на весь файл
+	BatchSize int `yaml:"batch_size"`
+	// После скольких плохих строк прекращаем импорт, 0 - на первой. Запрос может уменьшить
+	// ?max_errors=. Указатель, чтобы отличить 0 от незаданного значения
+	MaxErrors *int `yaml:"max_errors"`
+	// Роли, которым разрешен импорт. Пусто - импорт выключен: replace очищает всю таблицу
+	Roles []string `yaml:"roles"`
+	// Сколько байт максимум в одной строке NDJSON или записи CSV
+	MaxLineBytes int `yaml:"max_line_bytes"`
+}
+
+// Строка файла длиннее import.max_line_bytes. Дочитывать ее ради следующих строк
+// не стали бы, поэтому импорт прерываем
+type lineTooLongError struct {
+	// Номер строки, если известен: CSV-запись может занимать несколько строк
+	line int
+	max  int
+}
+
+func (e *lineTooLongError) Error() string {
+
+	if e.line > 0 {
+		return fmt.Sprintf("line %d is longer than %d bytes", e.line, e.max)
+	}
+
+	return fmt.Sprintf("CSV record is longer than %d bytes", e.max)
+}
+
+// replace без единой записанной строки только очистил бы таблицу
+var errEmptyReplace = errors.New("replace needs at least one valid line, the table is left as is")
+
+// Ошибка одной строки файла
+type ImportError struct {
+	Line   int    `json:"line"`
+	Field  string `json:"field,omitempty"`
+	Code   string `json:"code"`
+	Detail string `json:"detail"`
+}
+
+func (e *ImportError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Detail)
+}
+
+func lineError(line int, p *Problem) *ImportError {
+	return &ImportError{Line: line, Field: p.Field, Code: p.Code, Detail: p.Detail}
+}
+
+// Итог импорта
+type ImportResult struct {
+	Mode string `json:"mode"`
+	// Прочитано строк с данными
+	Lines int `json:"lines"`
+	// Записано и зафиксировано
+	Imported int `json:"imported"`
+	Failed   int `json:"failed"`
+	// Импорт прерван: ошибок больше max_errors
+	Aborted bool `json:"aborted"`
+	// Ошибки строк, не больше max_errors + 1
+	Errors []ImportError `json:"errors"`
+}
+
+// Источник строк импорта
+type importReader interface {
+	// Следующая строка: номер строки в файле и значения по публичным именам полей.
+	// io.EOF - конец, *ImportError - плохая строка, читать можно дальше
+	Next() (int, map[string]interface{}, error)
+}
+
+// CSV со строкой заголовков из публичных имен полей
+type csvImportReader struct {
+	reader *csv.Reader
+	limit  *recordLimitReader
+	header []string
+	null   string
+}
+
+// Не дает csv.Reader читать запись дальше max байт от ее начала. Буфер csv.Reader
+// дочитывается, только когда разобран весь, поэтому все прочитанное после start -
+// текущая запись
+type recordLimitReader struct {
+	r io.Reader
+	// Смещение начала текущей записи
+	start int64
+	read  int64
+	max   int
+}
+
+func (l *recordLimitReader) Read(p []byte) (int, error) {
+
+	if l.read-l.start > int64(l.max) {
+		return 0, &lineTooLongError{max: l.max}
+	}
+
+	n, err := l.r.Read(p)
+	l.read += int64(n)
+
+	return n, err
+}
+
+func newCSVImportReader(table TableInfo, body io.Reader, null string, maxRecord int) (*csvImportReader, error) {
+
+	limited := &recordLimitReader{r: body, max: maxRecord}
+
+	reader := csv.NewReader(limited)
+	reader.ReuseRecord = true
+
+	header, err := reader.Read()
+
+	var tooLong *lineTooLongError
+
+	if errors.As(err, &tooLong) {
+		return nil, NewProblem(http.StatusBadRequest, CodeInvalidBody, "bad CSV header: "+tooLong.Error())
+	}
+
+	if err == io.EOF {
+		return nil, NewProblem(http.StatusBadRequest, CodeInvalidBody, "CSV has no header row")
+	}
+
+	if err != nil {
+		return nil, NewProblem(http.StatusBadRequest, CodeInvalidBody, fmt.Sprintf("bad CSV header: %v", err))
+	}
+
+	seen := make(map[string]bool, len(header))
+
+	for _, name := range header {
+
+		if _, ok := fieldByName(table, name); !ok {
+			return nil, FieldProblem(http.StatusBadRequest, CodeUnknownField, name,
+				fmt.Sprintf("CSV column %s does not match any field", name))
+		}
+
+		if seen[name] {
+			return nil, FieldProblem(http.StatusBadRequest, CodeInvalidBody, name,
+				fmt.Sprintf("CSV column %s is repeated", name))
+		}
+
+		seen[name] = true
+	}
+
+	// запись переиспользуется, заголовок копируем
+	return &csvImportReader{reader: reader, limit: limited, header: append([]string(nil), header...), null: null}, nil
+}
+
+func (c *csvImportReader) Next() (int, map[string]interface{}, error) {
+
+	c.limit.start = c.reader.InputOffset()
+
+	record, err := c.reader.Read()
+
+	if err == io.EOF {
+		return 0, nil, io.EOF
+	}
+
+	var parseErr *csv.ParseError
+
+	if errors.As(err, &parseErr) {
+		return parseErr.StartLine, nil, &ImportError{Line: parseErr.StartLine, Code: CodeInvalidBody,
+			Detail: parseErr.Err.Error()}
+	}
+
+	if err != nil {
+		return 0, nil, err
+	}
+
+	line, _ := c.reader.FieldPos(0)
+
+	row := make(map[string]interface{}, len(record))
+
+	for i, value := range record {
+		if value == c.null {
+			row[c.header[i]] = nil
+		} else {
+			row[c.header[i]] = value
+		}
+	}
+
+	return line, row, nil
+}
+
+// Один JSON-объект в строке, пустые строки пропускаем
+type ndjsonImportReader struct {
+	reader *bufio.Reader
+	line   int
+	// Сколько байт максимум в строке
+	max int
+}
+
+// Строка целиком, но не длиннее max байт
+func (n *ndjsonImportReader) readLine() ([]byte, error) {
+
+	var data []byte
+
+	for {
+
+		chunk, err := n.reader.ReadSlice('\n')
+
+		if len(data)+len(chunk) > n.max {
+			return nil, &lineTooLongError{line: n.line + 1, max: n.max}
+		}
+
+		data = append(data, chunk...)
+
+		if err != bufio.ErrBufferFull {
+			return data, err
+		}
+	}
+}
+
+func (n *ndjsonImportReader) Next() (int, map[string]interface{}, error) {
+
+	for {
+
+		data, err := n.readLine()
+
+		if err != nil && err != io.EOF {
+			return 0, nil, err
+		}
+
+		if len(data) > 0 {
+			n.line++
+		}
+
+		data = bytes.TrimSpace(data)
+
+		if len(data) == 0 {
+			if err == io.EOF {
+				return 0, nil, io.EOF
+			}
+			continue
+		}
+
+		row := make(map[string]interface{})
+
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+
+		if errDecode := decoder.Decode(&row); errDecode != nil || decoder.More() {
+			return n.line, nil, &ImportError{Line: n.line, Code: CodeInvalidBody, Detail: "line must be one JSON object"}
+		}
+
+		return n.line, row, nil
+	}
+}
+
+// Формат тела: ?format= важнее Content-Type
+func importFormat(r *http.Request) (string, error) {
+
+	format := r.URL.Query().Get("format")
+
+	if format == "" {
+
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+		switch mediaType {
+		case "text/csv":
+			format = FormatCSV
+		case "application/x-ndjson", "application/ndjson":
+			format = FormatNDJSON
+		}
+	}
+
+	if format != FormatCSV && format != FormatNDJSON {
+		return "", NewProblem(http.StatusUnsupportedMediaType, CodeUnsupportedMedia,
+			"import accepts text/csv or application/x-ndjson")
+	}
+
+	return format, nil
+}
+
+// Значение поля из файла. Из CSV все приходит строками, из NDJSON - как в JSON
+func importValue(field FieldInfo, value interface{}) (interface{}, *Problem) {
+
+	problem := FieldProblem(http.StatusBadRequest, CodeInvalidType, field.Name,
+		fmt.Sprintf("field %s must be %s", field.Name, field.ColumnType))
+
+	switch v := value.(type) {
+
+	case nil:
+		// NULL в автоинкрементном ключе - новое значение
+		if !field.CouldNull && !field.IsKey {
+			return nil, FieldProblem(http.StatusBadRequest, CodeNotNull, field.Name,
+				fmt.Sprintf("field %s can not be null", field.Name))
+		}
+		return nil, nil
+
+	case string:
+
+		if isIntType(field.ColumnType) {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return nil, problem
+			}
+			return n, nil
+		}
+
+		if isNumericType(field.ColumnType) {
+			if _, err := strconv.ParseFloat(v, 64); err != nil {
+				return nil, problem
+			}
+		}
+
+		return v, nil
+
+	case json.Number:
+
+		if isIntType(field.ColumnType) {
+			n, err := v.Int64()
+			if err != nil {
+				return nil, problem
+			}
+			return n, nil
+		}
+
+		// DECIMAL передаем строкой, чтобы не терять точность
+		if isNumericType(field.ColumnType) {
+			return v.String(), nil
+		}
+
+	case bool:
+
+		if isIntType(field.ColumnType) {
+			if v {
+				return int64(1), nil
+			}
+			return int64(0), nil
+		}
+	}
+
+	return nil, problem
+}
+
+// Столбцы и значения строки в порядке полей таблицы
+func importRow(table TableInfo, row map[string]interface{}) ([]string, []interface{}, *Problem) {
+
+	for name := range row {
+		if _, ok := fieldByName(table, name); !ok {
+			return nil, nil, FieldProblem(http.StatusBadRequest, CodeUnknownField, name,
+				fmt.Sprintf("field %s does not exist", name))
+		}
+	}
+
+	columns := make([]string, 0, len(row))
+	values := make([]interface{}, 0, len(row))
+
+	for _, field := range table.Fields {
+
+		value, ok := row[field.Name]
+
+		if !ok {
+			continue
+		}
+
+		converted, problem := importValue(field, value)
+
+		if problem != nil {
+			return nil, nil, problem
+		}
+
+		columns = append(columns, field.Column())
+		values = append(values, converted)
+	}
+
+	if len(columns) == 0 {
+		return nil, nil, NewProblem(http.StatusBadRequest, CodeInvalidBody, "line has no fields")
+	}
+
+	return columns, values, nil
+}
+
+// Строка, ожидающая записи
+type pendingRow struct {
+	line   int
+	values []interface{}
+}
+
+// Пишет строки пачками: подряд идущие строки с одинаковыми столбцами - один
+// INSERT на много значений. Если он не прошел из-за данных, повторяем пачку
+// по одной строке, чтобы найти плохие
+type importer struct {
+	h         *Handler
+	ctx       context.Context
+	table     TableInfo
+	mode      string
+	batchSize int
+	maxErrors int
+
+	tx      *sql.Tx
+	txRows  int
+	columns []string
+	pending []pendingRow
+
+	result ImportResult
+	// Хоть одна транзакция уже зафиксирована
+	committed bool
+}
+
+// Ошибок строк больше max_errors
+var errTooManyErrors = errors.New("too many bad lines")
+
+// Записываем ошибку строки. errTooManyErrors - пора остановиться
+func (im *importer) fail(e *ImportError) error {
+
+	im.result.Failed++
+
+	// ошибку, из-за которой останавливаемся, тоже показываем
+	if len(im.result.Errors) <= im.maxErrors {
+		im.result.Errors = append(im.result.Errors, *e)
+	}
+
+	if im.result.Failed > im.maxErrors {
+		return errTooManyErrors
+	}
+
+	return nil
+}
+
+func (im *importer) begin() error {
+
+	tx, err := im.h.DB.BeginTx(im.ctx, nil)
+
+	if err != nil {
+		return err
+	}
+
+	im.tx = tx
+
+	if im.mode != ImportReplace {
+		return nil
+	}
+
+	// DELETE, а не TRUNCATE: TRUNCATE фиксирует транзакцию сам
+	_, err = im.exec("DELETE FROM " + im.table.Table())
+
+	return err
+}
+
+func (im *importer) exec(query string, args ...interface{}) (sql.Result, error) {
+
+	ctx, cancel := im.h.operationContext(im.ctx, "import")
+	defer cancel()
+
+	return im.h.execOn(ctx, im.tx, im.table.Name, "import", query, args...)
+}
+
+// Добавляем строку в пачку
+func (im *importer) add(line int, columns []string, values []interface{}) error {
+
+	if im.tx == nil {
+		if err := im.begin(); err != nil {
+			return err
+		}
+	}
+
+	if len(im.pending) > 0 && strings.Join(columns, ",") != strings.Join(im.columns, ",") {
+		if err := im.flush(); err != nil {
+			return err
+		}
+	}
+
+	im.columns = columns
+	im.pending = append(im.pending, pendingRow{line, values})
+
+	limit := im.batchSize
+
+	if limit > maxPlaceholders/len(columns) {
+		limit = maxPlaceholders / len(columns)
+	}
+
+	if len(im.pending) < limit {
+		return nil
+	}
+
+	if err := im.flush(); err != nil {
+		return err
+	}
+
+	if im.mode != ImportReplace && im.txRows >= im.batchSize {
+		return im.commit()
+	}
+
+	return nil
+}
+
+// INSERT для rows строк со столбцами im.columns
+func (im *importer) insertSQL(rows int) string {
+
+	placeholders := "(" + strings.TrimSuffix(strings.Repeat("?,", len(im.columns)), ",") + ")"
+
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES %s", im.table.Table(), strings.Join(im.columns, ","),
+		strings.TrimSuffix(strings.Repeat(placeholders+",", rows), ","))
+
+	if im.mode != ImportUpsert {
+		return query
+	}
+
+	updates := make([]string, 0, len(im.columns))
+
+	// VALUES(col) устарел с MySQL 8.0.20, вставляемая строка доступна по алиасу new
+	for _, column := range im.columns {
+		if column != im.table.ID {
+			updates = append(updates, fmt.Sprintf("%s = new.%s", column, column))
+		}
+	}
+
+	// одни ключи: обновлять нечего, но дубликат не ошибка
+	if len(updates) == 0 {
+		updates = append(updates, fmt.Sprintf("%s = new.%s", im.columns[0], im.columns[0]))
+	}
+
+	return query + " AS new ON DUPLICATE KEY UPDATE " + strings.Join(updates, ", ")
+}
+
+// Ошибка из-за данных строки, а не из-за БД или соединения
+func rowProblem(table TableInfo, err error) *Problem {
+
+	p := tableProblem(table, err)
+
+	if p == nil || p.Code == CodeLockConflict {
+		return nil
+	}
+
+	return p
+}
+
+// Пишем накопленную пачку
+func (im *importer) flush() error {
+
+	if len(im.pending) == 0 {
+		return nil
+	}
+
+	args := make([]interface{}, 0, len(im.pending)*len(im.columns))
+
+	for _, row := range im.pending {
+		args = append(args, row.values...)
+	}
+
+	_, err := im.exec(im.insertSQL(len(im.pending)), args...)
+
+	if err == nil {
+		im.txRows += len(im.pending)
+		im.pending = im.pending[:0]
+		return nil
+	}
+
+	if rowProblem(im.table, err) == nil {
+		return err
+	}
+
+	// неудачный INSERT откатывается целиком, транзакция остается
+	query := im.insertSQL(1)
+
+	for _, row := range im.pending {
+
+		_, err = im.exec(query, row.values...)
+
+		if err == nil {
+			im.txRows++
+			continue
+		}
+
+		p := rowProblem(im.table, err)
+
+		if p == nil {
+			return err
+		}
+
+		if err = im.fail(lineError(row.line, p)); err != nil {
+			return err
+		}
+	}
+
+	im.pending = im.pending[:0]
+
+	return nil
+}
+
+func (im *importer) commit() error {
+
+	err := im.tx.Commit()
+	im.tx = nil
+
+	if err != nil {
+		return err
+	}
+
+	im.result.Imported += im.txRows
+	im.txRows = 0
+	im.committed = true
+
+	return nil
+}
+
+func (im *importer) rollback() {
+
+	if im.tx != nil {
+		im.tx.Rollback() //nolint:errcheck
+		im.tx = nil
+	}
+
+	im.txRows = 0
+}
+
+// Читаем все строки и пишем их в БД
+func (im *importer) run(reader importReader) error {
+
+	for {
+
+		line, row, err := reader.Next()
+
+		if err == io.EOF {
+			break
+		}
+
+		var bad *ImportError
+
+		if errors.As(err, &bad) {
+			im.result.Lines++
+			if err = im.fail(bad); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		im.result.Lines++
+
+		columns, values, problem := importRow(im.table, row)
+
+		if problem != nil {
+			if err = im.fail(lineError(line, problem)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err = im.add(line, columns, values); err != nil {
+			return err
+		}
+	}
+
+	if err := im.flush(); err != nil {
+		return err
+	}
+
+	// пустой файл или одни плохие строки не должны очищать таблицу
+	if im.mode == ImportReplace && im.txRows == 0 {
+		return errEmptyReplace
+	}
+
+	if im.tx == nil {
+		return nil
+	}
+
+	return im.commit()
+}
+
+// Хендлер для загрузки записей из CSV или NDJSON потоком: ?mode=insert|upsert|replace,
+// ?max_errors=N, ?null= для CSV. Вызывается по эндпоинту "/{table}/_import" [POST]
+func (h *Handler) Import(w http.ResponseWriter, r *http.Request) {
+
+	if len(h.Config.Import.Roles) == 0 {
+		writeError(w, r, http.StatusNotFound, CodeNotFound, "import is disabled")
+		return
+	}
+
+	if !h.authorize(w, r, h.Config.Import.Roles) {
+		return
+	}
+
+	table := strings.Split(r.URL.Path, "/")[1]
+
+	tables := h.tables()
+
+	cond, idx, _ := contains(tables, table)
+
+	if !cond {
+		writeError(w, r, http.StatusNotFound, CodeUnknownTable, fmt.Sprintf("unknown table %s", table))
+		return
+	}
+
+	if readOnlyView(w, r, tables[idx]) {
+		return
+	}
+
+	mode := r.URL.Query().Get("mode")
+
+	if mode == "" {
+		mode = ImportInsert
+	}
+
+	if mode != ImportInsert && mode != ImportUpsert && mode != ImportReplace {
+		writeProblem(w, r, FieldProblem(http.StatusBadRequest, CodeInvalidParam, "mode",
+			fmt.Sprintf("mode must be %s, %s or %s", ImportInsert, ImportUpsert, ImportReplace)))
+		return
+	}
+
+	maxErrors := *h.Config.Import.MaxErrors
+
+	if value := r.URL.Query().Get("max_errors"); value != "" {
+
+		n, err := strconv.Atoi(value)
+
+		if err != nil || n < 0 || n > *h.Config.Import.MaxErrors {
+			writeProblem(w, r, FieldProblem(http.StatusBadRequest, CodeInvalidParam, "max_errors",
+				fmt.Sprintf("max_errors must be from 0 to %d", *h.Config.Import.MaxErrors)))
+			return
+		}
+
+		maxErrors = n
+	}
+
+	format, err := importFormat(r)
+
+	if err != nil {
+		writeProblem(w, r, err.(*Problem))
+		return
+	}
+
+	// файл в несколько гигабайт читается дольше таймаутов сервера
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(time.Time{})  //nolint:errcheck
+	rc.SetWriteDeadline(time.Time{}) //nolint:errcheck
+
+	var reader importReader
+
+	if format == FormatCSV {
+		reader, err = newCSVImportReader(tables[idx], r.Body, h.exportNull(r), h.Config.Import.MaxLineBytes)
+	} else {
+		reader = &ndjsonImportReader{reader: bufio.NewReaderSize(r.Body, 64*1024), max: h.Config.Import.MaxLineBytes}
+	}
+
+	if err != nil {
+		writeProblem(w, r, err.(*Problem))
+		return
+	}
+
+	im := &importer{
+		h:         h,
+		ctx:       r.Context(),
+		table:     tables[idx],
+		mode:      mode,
+		batchSize: h.Config.Import.BatchSize,
+		maxErrors: maxErrors,
+		result:    ImportResult{Mode: mode, Errors: make([]ImportError, 0)},
+	}
+
+	err = im.run(reader)
+
+	if err != nil {
+		im.rollback()
+	}
+
+	result := im.result
+
+	if err == errTooManyErrors {
+		result.Aborted = true
+		err = nil
+	}
+
+	requestLogger(r).Info("import finished", "table", table, "mode", mode, "lines", result.Lines,
+		"imported", result.Imported, "failed", result.Failed, "aborted", result.Aborted, "error", err)
+
+	if result.Imported > 0 && h.auditImport(r, tables[idx], result) != nil {
+		writeInternalError(w, r)
+		return
+	}
+
+	// ошибки в самом файле, а не в БД
+	var bodyProblem *Problem
+	var tooLong *lineTooLongError
+
+	switch {
+	case err == errEmptyReplace:
+		bodyProblem = NewProblem(http.StatusBadRequest, CodeInvalidBody, err.Error())
+	case errors.As(err, &tooLong):
+		bodyProblem = NewProblem(http.StatusBadRequest, CodeInvalidBody, tooLong.Error())
+	}
+
+	if bodyProblem != nil && !im.committed {
+		writeProblem(w, r, bodyProblem)
+		return
+	}
+
+	if err != nil && !im.committed {
+		h.tableError(r.Context(), w, r, tables[idx], err, "bad import", "table", table)
+		return
+	}
+
+	body := map[string]interface{}{"response": result}
+
+	// часть пачек уже зафиксирована: отдаем итог и ошибку рядом
+	if err != nil {
+
+		p := tableProblem(tables[idx], err)
+
+		if bodyProblem != nil {
+			p = bodyProblem
+		}
+
+		if p == nil {
+			p = streamProblem(r.Context(), r)
+		}
+
+		p.RequestID = RequestIDFromContext(r.Context())
+		body["error"] = p
+	}
+
+	writeJSON(w, r, http.StatusOK, body)
+}
+
+// Одна запись аудита на весь импорт, а не на каждую строку
+func (h *Handler) auditImport(r *http.Request, table TableInfo, result ImportResult) error {
+
+	return h.writeAudit(r, AuditEntry{
+		Table:     table.Name,
+		Operation: AuditImport,
+		After: map[string]interface{}{
+			"mode":     result.Mode,
+			"lines":    result.Lines,
+			"imported": result.Imported,
+			"failed":   result.Failed,
+		},
+	})
+}
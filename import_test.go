@@ -0,0 +1,270 @@
+package dbexplorer
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var importTable = TableInfo{Name: "items", DBName: "tbl_items", ID: "id", Fields: []FieldInfo{
+	{Name: "id", ColumnType: "int", IsKey: true},
+	{Name: "title", DBName: "ttl", ColumnType: "varchar(255)"},
+	{Name: "price", ColumnType: "decimal(10,2)", CouldNull: true},
+	{Name: "description", ColumnType: "text", CouldNull: true},
+}}
+
+type importLine struct {
+	line int
+	row  map[string]interface{}
+	code string
+}
+
+func readAll(t *testing.T, reader importReader) []importLine {
+
+	lines := make([]importLine, 0)
+
+	for {
+
+		line, row, err := reader.Next()
+
+		if err == io.EOF {
+			return lines
+		}
+
+		var bad *ImportError
+
+		if errors.As(err, &bad) {
+			lines = append(lines, importLine{line: line, code: bad.Code})
+			continue
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		lines = append(lines, importLine{line: line, row: row})
+	}
+}
+
+func TestCSVImportReader(t *testing.T) {
+
+	body := "title,price,description\r\n" +
+		"a,1.5,\"multi\nline\"\r\n" +
+		"b,\\N,x\r\n" +
+		"c,2\r\n" +
+		"d,3,\"y\"\r\n"
+
+	reader, err := newCSVImportReader(importTable, strings.NewReader(body), `\N`, 1024)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []importLine{
+		{line: 2, row: map[string]interface{}{"title": "a", "price": "1.5", "description": "multi\nline"}},
+		{line: 4, row: map[string]interface{}{"title": "b", "price": nil, "description": "x"}},
+		{line: 5, code: CodeInvalidBody},
+		{line: 6, row: map[string]interface{}{"title": "d", "price": "3", "description": "y"}},
+	}
+
+	if got := readAll(t, reader); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	for _, header := range []string{"", "title,password\n", "title,title\n"} {
+		if _, err := newCSVImportReader(importTable, strings.NewReader(header), "", 1024); err == nil {
+			t.Errorf("%q: expected error", header)
+		}
+	}
+}
+
+func TestImportLineLimit(t *testing.T) {
+
+	long := strings.Repeat("x", 100)
+
+	// запись с переводами строк внутри кавычек считается целиком
+	csvBody := "title,description\r\n" + "a,b\r\n" + "c,\"" + strings.Repeat(long+"\n", 200) + "\"\r\n"
+
+	csvReader, err := newCSVImportReader(importTable, strings.NewReader(csvBody), `\N`, 1024)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ndjsonBody := `{"title": "a"}` + "\n\n" + `{"title": "` + strings.Repeat(long, 20) + `"}` + "\n"
+
+	ndjsonReader := &ndjsonImportReader{reader: bufio.NewReaderSize(strings.NewReader(ndjsonBody), 16), max: 1024}
+
+	for _, reader := range []importReader{csvReader, ndjsonReader} {
+
+		if _, _, err := reader.Next(); err != nil {
+			t.Fatalf("%T: first line: %v", reader, err)
+		}
+
+		var tooLong *lineTooLongError
+
+		if _, _, err := reader.Next(); !errors.As(err, &tooLong) {
+			t.Errorf("%T: got %v, want line too long", reader, err)
+		}
+	}
+
+	if _, err := newCSVImportReader(importTable, strings.NewReader(strings.Repeat(long, 20)), "", 1024); err == nil {
+		t.Error("long header: expected error")
+	}
+}
+
+func TestNDJSONImportReader(t *testing.T) {
+
+	body := `{"title": "a", "price": 1.5}` + "\n\n" + `not json` + "\n" + `{"title": "b"} {"title": "c"}` + "\n" + `{"title": "d"}`
+
+	reader := &ndjsonImportReader{reader: bufio.NewReader(strings.NewReader(body)), max: 1024}
+
+	want := []importLine{
+		{line: 1, row: map[string]interface{}{"title": "a", "price": json.Number("1.5")}},
+		{line: 3, code: CodeInvalidBody},
+		{line: 4, code: CodeInvalidBody},
+		{line: 5, row: map[string]interface{}{"title": "d"}},
+	}
+
+	if got := readAll(t, reader); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestImportRow(t *testing.T) {
+
+	columns, values, p := importRow(importTable, map[string]interface{}{
+		"description": nil, "id": "7", "title": "x", "price": json.Number("9.99"),
+	})
+
+	if p != nil || !reflect.DeepEqual(columns, []string{"id", "ttl", "price", "description"}) ||
+		!reflect.DeepEqual(values, []interface{}{int64(7), "x", "9.99", nil}) {
+		t.Errorf("got %v %v %v", columns, values, p)
+	}
+
+	cases := []struct {
+		row   map[string]interface{}
+		code  string
+		field string
+	}{
+		{map[string]interface{}{"password": "x"}, CodeUnknownField, "password"},
+		{map[string]interface{}{"id": "seven"}, CodeInvalidType, "id"},
+		{map[string]interface{}{"price": "cheap"}, CodeInvalidType, "price"},
+		{map[string]interface{}{"title": json.Number("1")}, CodeInvalidType, "title"},
+		{map[string]interface{}{"title": nil}, CodeNotNull, "title"},
+		{map[string]interface{}{"title": map[string]interface{}{}}, CodeInvalidType, "title"},
+		{map[string]interface{}{}, CodeInvalidBody, ""},
+	}
+
+	for _, c := range cases {
+		if _, _, p := importRow(importTable, c.row); p == nil || p.Code != c.code || p.Field != c.field {
+			t.Errorf("%v: got %v, want %s on %s", c.row, p, c.code, c.field)
+		}
+	}
+}
+
+func TestImportInsertSQL(t *testing.T) {
+
+	im := &importer{table: importTable, mode: ImportInsert, columns: []string{"id", "ttl"}}
+
+	if got := im.insertSQL(2); got != "INSERT INTO tbl_items (id,ttl) VALUES (?,?),(?,?)" {
+		t.Errorf("insert: got %s", got)
+	}
+
+	im.mode = ImportUpsert
+
+	if got := im.insertSQL(1); got != "INSERT INTO tbl_items (id,ttl) VALUES (?,?) AS new ON DUPLICATE KEY UPDATE ttl = new.ttl" {
+		t.Errorf("upsert: got %s", got)
+	}
+
+	im.columns = []string{"id"}
+
+	if got := im.insertSQL(1); got != "INSERT INTO tbl_items (id) VALUES (?) AS new ON DUPLICATE KEY UPDATE id = new.id" {
+		t.Errorf("upsert of keys: got %s", got)
+	}
+}
+
+func TestImportFail(t *testing.T) {
+
+	im := &importer{maxErrors: 1}
+
+	if err := im.fail(&ImportError{Line: 1}); err != nil {
+		t.Errorf("first error: got %v", err)
+	}
+
+	if err := im.fail(&ImportError{Line: 2}); err != errTooManyErrors {
+		t.Errorf("second error: got %v", err)
+	}
+
+	if im.result.Failed != 2 || len(im.result.Errors) != 2 {
+		t.Errorf("got %+v", im.result)
+	}
+}
+
+func TestImportValidation(t *testing.T) {
+
+	cfg := DefaultConfig()
+	cfg.Import.Roles = []string{"writer"}
+	cfg.Auth.Keys = map[string][]string{"k1": {"writer"}}
+
+	h := &Handler{Config: cfg.withDefaults()}
+	h.schema.Store(&Schema{Tables: []TableInfo{importTable, {Name: "report", View: true}}})
+
+	cases := []struct {
+		url         string
+		contentType string
+		status      int
+	}{
+		{"/users/_import", "text/csv", http.StatusNotFound},
+		{"/items/_import?key=", "text/csv", http.StatusUnauthorized},
+		// без ключа не рассказываем, какие таблицы есть
+		{"/users/_import?key=", "text/csv", http.StatusUnauthorized},
+		{"/report/_import?key=", "text/csv", http.StatusUnauthorized},
+		{"/report/_import", "text/csv", http.StatusMethodNotAllowed},
+		{"/items/_import?mode=merge", "text/csv", http.StatusBadRequest},
+		{"/items/_import?max_errors=1000", "text/csv", http.StatusBadRequest},
+		{"/items/_import", "application/json", http.StatusUnsupportedMediaType},
+		{"/items/_import?format=csv", "", http.StatusBadRequest},
+	}
+
+	for _, c := range cases {
+
+		r := httptest.NewRequest(http.MethodPost, c.url, strings.NewReader(""))
+		r.Header.Set("Content-Type", c.contentType)
+
+		if !strings.Contains(c.url, "key=") {
+			r.Header.Set("X-API-Key", "k1")
+		}
+
+		w := httptest.NewRecorder()
+
+		h.Import(w, r)
+
+		if w.Code != c.status {
+			t.Errorf("%s %s: got %d %s, want %d", c.url, c.contentType, w.Code, w.Body, c.status)
+		}
+	}
+
+	// max_errors: 0 не заменяется значением по умолчанию
+	cfg.Import.MaxErrors = intPtr(0)
+	h = &Handler{Config: cfg.withDefaults()}
+	h.schema.Store(&Schema{Tables: []TableInfo{importTable}})
+
+	r := httptest.NewRequest(http.MethodPost, "/items/_import?max_errors=1", strings.NewReader(""))
+	r.Header.Set("Content-Type", "text/csv")
+	r.Header.Set("X-API-Key", "k1")
+
+	w := httptest.NewRecorder()
+
+	h.Import(w, r)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("max_errors above configured 0: got %d %s", w.Code, w.Body)
+	}
+}
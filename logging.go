@@ -0,0 +1,142 @@
+package dbexplorer
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Настройки логирования
+type LogConfig struct {
+	// Уровень: debug, info, warn, error
+	Level string `yaml:"level"`
+	// Формат: text или json
+	Format string `yaml:"format"`
+	// Логировать SQL-запросы
+	SQL bool `yaml:"sql"`
+	// Логировать данные: аргументы SQL-запросов и тела ответов
+	Payloads bool `yaml:"payloads"`
+	// Готовый логгер. Если задан, Level и Format не используются
+	Logger *slog.Logger `yaml:"-"`
+}
+
+// Создаем логгер по настройкам
+func NewLogger(cfg LogConfig, out io.Writer) (*slog.Logger, error) {
+
+	if cfg.Logger != nil {
+		return cfg.Logger, nil
+	}
+
+	var level slog.Level
+
+	err := level.UnmarshalText([]byte(cfg.Level))
+
+	if err != nil {
+		return nil, fmt.Errorf("bad log level %q", cfg.Level)
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+
+	switch cfg.Format {
+	case "text":
+		return slog.New(slog.NewTextHandler(out, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(out, opts)), nil
+	}
+
+	return nil, fmt.Errorf("bad log format %q", cfg.Format)
+}
+
+type contextKey int
+
+const (
+	requestIDKey contextKey = iota
+	loggerKey
+)
+
+// Заголовок с идентификатором запроса
+const RequestIDHeader = "X-Request-ID"
+
+// Идентификатор текущего запроса
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// Логгер текущего запроса с его идентификатором
+func loggerFrom(ctx context.Context) *slog.Logger {
+
+	if logger, ok := ctx.Value(loggerKey).(*slog.Logger); ok {
+		return logger
+	}
+
+	return slog.Default()
+}
+
+func requestLogger(r *http.Request) *slog.Logger {
+	return loggerFrom(r.Context())
+}
+
+func newRequestID() string {
+
+	b := make([]byte, 16)
+
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+
+	return hex.EncodeToString(b)
+}
+
+// Берем идентификатор запроса из X-Request-ID или создаем новый, возвращаем его клиенту
+// и кладем в контекст вместе с логгером. В конце пишем строку access-лога
+func (h *Handler) withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		start := time.Now()
+
+		id := r.Header.Get(RequestIDHeader)
+
+		// чужой идентификатор попадает в логи, поэтому не доверяем ему слепо
+		if id == "" || len(id) > 128 || strings.ContainsAny(id, "\r\n\"") {
+			id = newRequestID()
+		}
+
+		w.Header().Set(RequestIDHeader, id)
+
+		logger := h.Logger.With("request_id", id)
+
+		ctx := context.WithValue(r.Context(), requestIDKey, id)
+		ctx = context.WithValue(ctx, loggerKey, logger)
+
+		rec := &statusRecorder{ResponseWriter: w}
+
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		level := slog.LevelInfo
+
+		// пробы и сбор метрик дергают сервис постоянно
+		switch r.URL.Path {
+		case "/healthz", "/readyz", "/metrics":
+			level = slog.LevelDebug
+		}
+
+		logger.Log(ctx, level, "request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.status,
+			"duration", time.Since(start),
+			"remote", r.RemoteAddr,
+		)
+	})
+}
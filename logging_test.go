@@ -0,0 +1,64 @@
+package dbexplorer
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequestIDMiddleware(t *testing.T) {
+
+	out := &bytes.Buffer{}
+
+	logger, err := NewLogger(LogConfig{Level: "info", Format: "json"}, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := &Handler{Logger: logger}
+
+	var seen string
+
+	handler := h.withRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = RequestIDFromContext(r.Context())
+		requestLogger(r).Info("inside")
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/items", nil)
+	req.Header.Set(RequestIDHeader, "abc-123")
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if seen != "abc-123" || rec.Header().Get(RequestIDHeader) != "abc-123" {
+		t.Fatalf("incoming request id must be kept: ctx %q, header %q", seen, rec.Header().Get(RequestIDHeader))
+	}
+
+	lines := bytes.Split(bytes.TrimSpace(out.Bytes()), []byte("\n"))
+
+	if len(lines) != 2 {
+		t.Fatalf("expected handler and access log lines, got %s", out.String())
+	}
+
+	for _, line := range lines {
+
+		entry := map[string]interface{}{}
+
+		if err = json.Unmarshal(line, &entry); err != nil {
+			t.Fatal(err)
+		}
+
+		if entry["request_id"] != "abc-123" {
+			t.Errorf("log line without request id: %s", line)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/items", nil))
+
+	if len(seen) != 32 || rec.Header().Get(RequestIDHeader) != seen {
+		t.Fatalf("request id must be generated, got %q", seen)
+	}
+}
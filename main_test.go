@@ -1,4 +1,4 @@
-package main
+package dbexplorer
 
 import (
 	"database/sql"
@@ -26,14 +26,50 @@ type Case struct {
 	Status int
 	Result interface{}
 	Body   interface{}
+	// Заголовки запроса, например API-ключ для служебных эндпоинтов
+	Headers map[string]string
+	// Ожидаемые заголовки ответа
+	RespHeaders map[string]string
+	// Ожидаемое тело ответа не в JSON, например CSV, сравнивается как есть
+	Raw string
+	// Тело запроса как есть, например CSV для импорта. Content-Type задается в Headers
+	RawBody string
 }
 
 var (
 	client = &http.Client{Timeout: time.Second}
+	// база из docker-compose.yml
+	DSN = "root:1234@tcp(localhost:3306)/golang?charset=utf8"
 )
 
+// API-ключ для эндпоинтов, закрытых ролями
+const testAdminKey = "test-admin-key"
+
+// Настройки по умолчанию плюс все, что выключено без ролей
+func testConfig() Config {
+
+	cfg := DefaultConfig()
+
+	cfg.Audit.Sink = AuditSinkDB
+	cfg.Audit.Roles = []string{"admin"}
+	cfg.Auth.Keys = map[string][]string{testAdminKey: {"admin"}}
+	cfg.RPC.Include = []string{"author_items", "item_title"}
+	cfg.RPC.Roles = []string{"admin"}
+	cfg.SQL.Roles = []string{"admin"}
+	cfg.Import.Roles = []string{"admin"}
+	cfg.SQL.MaxRows = 1
+	cfg.Queries = map[string]SavedQuery{
+		"by_author": {SQL: "SELECT id, title FROM items WHERE updated = :who ORDER BY id"},
+		"all_items": {SQL: "SELECT id, title FROM items ORDER BY id"},
+	}
+
+	return cfg
+}
+
 func PrepareTestApis(db *sql.DB) {
 	qs := []string{
+		`DROP TABLE IF EXISTS _audit_log;`,
+
 		`DROP TABLE IF EXISTS items;`,
 
 		`CREATE TABLE items (
@@ -62,6 +98,23 @@ func PrepareTestApis(db *sql.DB) {
 
 		`INSERT INTO users (user_id, login, password, email, info, updated) VALUES
 (1,	'rvasily',	'love',	'rvasily@example.com',	'none',	NULL);`,
+
+		`DROP PROCEDURE IF EXISTS author_items;`,
+
+		`CREATE PROCEDURE author_items(IN author varchar(255), OUT total int)
+BEGIN
+  SELECT id, title FROM items WHERE updated = author ORDER BY id;
+  SELECT COUNT(*) INTO total FROM items WHERE updated = author;
+END`,
+
+		`DROP FUNCTION IF EXISTS item_title;`,
+
+		`CREATE FUNCTION item_title(item_id int) RETURNS varchar(255) READS SQL DATA
+  RETURN (SELECT title FROM items WHERE id = item_id);`,
+
+		`DROP PROCEDURE IF EXISTS hidden_cleanup;`,
+
+		`CREATE PROCEDURE hidden_cleanup() DELETE FROM items;`,
 	}
 
 	for _, q := range qs {
@@ -76,6 +129,10 @@ func CleanupTestApis(db *sql.DB) {
 	qs := []string{
 		`DROP TABLE IF EXISTS items;`,
 		`DROP TABLE IF EXISTS users;`,
+		`DROP TABLE IF EXISTS _audit_log;`,
+		`DROP PROCEDURE IF EXISTS author_items;`,
+		`DROP FUNCTION IF EXISTS item_title;`,
+		`DROP PROCEDURE IF EXISTS hidden_cleanup;`,
 	}
 	for _, q := range qs {
 		_, err := db.Exec(q)
@@ -101,7 +158,7 @@ func TestApis(t *testing.T) {
 	// возможно вам будет удобно закомментировать это чтобы смотреть результат после теста
 	// defer CleanupTestApis(db)
 
-	handler, err := NewDBExplorer(db) //nolint:typecheck
+	handler, err := NewDBExplorerWithConfig(db, testConfig()) //nolint:typecheck
 	if err != nil {
 		panic(err)
 	}
@@ -114,14 +171,369 @@ func TestApis(t *testing.T) {
 			Result: CR{
 				"response": CR{
 					"tables": []string{"items", "users"},
+					"views":  []string{},
 				},
 			},
 		},
 		Case{
 			Path:   "/unknown_table",
 			Status: http.StatusNotFound,
+			Result: problem(http.StatusNotFound, "unknown_table", "", "unknown table unknown_table"),
+		},
+		// перечитывание схемы - только для роли admin
+		Case{
+			Path:   "/_admin/reload",
+			Method: http.MethodPost,
+			Status: http.StatusUnauthorized,
+			Result: problem(http.StatusUnauthorized, "unauthorized", "", "valid API key is required"),
+		},
+		Case{
+			Path:    "/_admin/reload",
+			Method:  http.MethodPost,
+			Headers: map[string]string{"X-API-Key": testAdminKey},
+			Result: CR{
+				"response": CR{
+					"tables":   []string{"items", "users"},
+					"routines": []string{"author_items", "item_title"},
+					"changed":  false,
+				},
+			},
+		},
+		// хранимые процедуры - только перечисленные в rpc.include и только для роли admin
+		Case{
+			Path:   "/_rpc/author_items",
+			Method: http.MethodPost,
+			Body:   CR{"author": "rvasily"},
+			Status: http.StatusUnauthorized,
+			Result: problem(http.StatusUnauthorized, "unauthorized", "", "valid API key is required"),
+		},
+		Case{
+			Path:    "/_rpc/author_items",
+			Method:  http.MethodPost,
+			Headers: map[string]string{"X-API-Key": testAdminKey},
+			Body:    CR{"author": "rvasily"},
+			Result: CR{
+				"response": CR{
+					"result_sets": [][]CR{
+						{
+							CR{"id": 1, "title": "database/sql"},
+						},
+					},
+					"out":       CR{"total": 1},
+					"truncated": false,
+				},
+			},
+		},
+		Case{
+			Path:    "/_rpc/item_title",
+			Method:  http.MethodPost,
+			Headers: map[string]string{"X-API-Key": testAdminKey},
+			Body:    CR{"item_id": 2},
+			Result: CR{
+				"response": CR{
+					"result": "memcache",
+				},
+			},
+		},
+		Case{
+			Path:    "/_rpc/item_title",
+			Method:  http.MethodPost,
+			Headers: map[string]string{"X-API-Key": testAdminKey},
+			Body:    CR{"item_id": 2, "title": "x"},
+			Status:  http.StatusBadRequest,
+			Result:  problem(http.StatusBadRequest, "unknown_field", "title", "item_title has no parameter title"),
+		},
+		Case{
+			Path:    "/_rpc/hidden_cleanup",
+			Method:  http.MethodPost,
+			Headers: map[string]string{"X-API-Key": testAdminKey},
+			Body:    CR{},
+			Status:  http.StatusNotFound,
+			Result:  problem(http.StatusNotFound, "unknown_routine", "", "unknown routine hidden_cleanup"),
+		},
+		// произвольный SELECT - только для роли admin и только на чтение
+		Case{
+			Path:   "/_sql",
+			Method: http.MethodPost,
+			Body:   CR{"query": "SELECT id, title FROM items WHERE id = ?", "args": []interface{}{1}},
+			Status: http.StatusUnauthorized,
+			Result: problem(http.StatusUnauthorized, "unauthorized", "", "valid API key is required"),
+		},
+		Case{
+			Path:    "/_sql",
+			Method:  http.MethodPost,
+			Headers: map[string]string{"X-API-Key": testAdminKey},
+			Body:    CR{"query": "SELECT id, title FROM items WHERE id = ?", "args": []interface{}{1}},
+			Result: CR{
+				"response": CR{
+					"columns": []CR{
+						CR{"name": "id", "database_type": "INT", "nullable": false},
+						CR{"name": "title", "database_type": "VARCHAR", "nullable": false},
+					},
+					"rows": []interface{}{
+						[]interface{}{1, "database/sql"},
+					},
+					"truncated": false,
+				},
+			},
+		},
+		Case{
+			Path:    "/_sql",
+			Method:  http.MethodPost,
+			Headers: map[string]string{"X-API-Key": testAdminKey},
+			Body:    CR{"query": "UPDATE items SET title = 'x'"},
+			Status:  http.StatusBadRequest,
+			Result:  problem(http.StatusBadRequest, "not_read_only", "query", "only SELECT statements are allowed"),
+		},
+		Case{
+			Path:    "/_sql",
+			Method:  http.MethodPost,
+			Headers: map[string]string{"X-API-Key": testAdminKey},
+			Body:    CR{"query": "SELECT 1; DELETE FROM items"},
+			Status:  http.StatusBadRequest,
+			Result:  problem(http.StatusBadRequest, "not_read_only", "query", "multiple statements are not allowed"),
+		},
+		// сохраненные запросы, не больше sql.max_rows записей
+		Case{
+			Path: "/_queries",
+			Result: CR{
+				"response": CR{
+					"queries": []CR{
+						CR{"name": "all_items", "params": CR{}},
+						CR{"name": "by_author", "params": CR{"who": CR{"type": "string"}}},
+					},
+				},
+			},
+		},
+		Case{
+			Path:  "/_queries/by_author",
+			Query: "who=rvasily",
+			Result: CR{
+				"response": CR{
+					"records": []CR{
+						CR{"id": 1, "title": "database/sql"},
+					},
+					"truncated": false,
+				},
+			},
+		},
+		Case{
+			Path:   "/_queries/by_author",
+			Status: http.StatusBadRequest,
+			Result: problem(http.StatusBadRequest, "invalid_param", "who", "parameter who is required"),
+		},
+		Case{
+			Path:   "/_queries/by_author",
+			Query:  "who=rvasily&page=1",
+			Status: http.StatusBadRequest,
+			Result: problem(http.StatusBadRequest, "unknown_field", "page", "query by_author has no parameter page"),
+		},
+		// агрегаты: группы страницами, всего групп - в X-Total-Count
+		Case{
+			Path:        "/items/_aggregate",
+			Query:       "group_by=updated&count=*&limit=1",
+			RespHeaders: map[string]string{"X-Total-Count": "2", "X-Limit": "1", "X-Offset": "0"},
+			Result: CR{
+				"response": CR{
+					"records": []CR{
+						CR{"updated": nil, "count": 1},
+					},
+				},
+			},
+		},
+		Case{
+			Path:  "/items/_aggregate",
+			Query: "count=*&max=id",
+			Result: CR{
+				"response": CR{
+					"records": []CR{
+						CR{"count": 2, "max_id": 2},
+					},
+				},
+			},
+		},
+		Case{
+			Path:   "/items/_aggregate",
+			Query:  "sum=title",
+			Status: http.StatusBadRequest,
+			Result: problem(http.StatusBadRequest, "invalid_type", "sum", "can not sum title: field is varchar(255), not a number"),
+		},
+		// поиск: FULLTEXT-индекса у items нет, ищем через LIKE по текстовым полям
+		Case{
+			Path:  "/items",
+			Query: "q=memcache&snippets=1",
+			Result: CR{
+				"response": CR{
+					"records": []CR{
+						CR{
+							"id":          2,
+							"title":       "memcache",
+							"description": "Рассказать про мемкеш с примером использования",
+							"updated":     nil,
+							"_score":      1,
+							"_snippets":   CR{"title": "<mark>memcache</mark>"},
+						},
+					},
+				},
+			},
+		},
+		Case{
+			Path:  "/items",
+			Query: "q=nothing+like+this",
+			Result: CR{
+				"response": CR{
+					"records": []CR{},
+				},
+			},
+		},
+		// различные значения столбца, самые частые первыми
+		Case{
+			Path:  "/items/_distinct/updated",
+			Query: "limit=10",
+			Result: CR{
+				"response": CR{
+					"field": "updated",
+					"values": []CR{
+						CR{"value": nil, "count": 1},
+						CR{"value": "rvasily", "count": 1},
+					},
+				},
+			},
+		},
+		Case{
+			Path:  "/items/_distinct/id",
+			Query: "id=1",
+			Result: CR{
+				"response": CR{
+					"field": "id",
+					"values": []CR{
+						CR{"value": 1, "count": 1},
+					},
+				},
+			},
+		},
+		Case{
+			Path:   "/items/_distinct/updated",
+			Status: http.StatusBadRequest,
+			Result: problem(http.StatusBadRequest, "invalid_param", "limit",
+				"field updated is varchar(255) and may have too many values: limit is required"),
+		},
+		Case{
+			Path:   "/items/_distinct/unknown",
+			Status: http.StatusNotFound,
+			Result: problem(http.StatusNotFound, "unknown_field", "", "unknown field unknown"),
+		},
+		// потоковые форматы выгрузки
+		Case{
+			Path:        "/items",
+			Query:       "format=ndjson",
+			RespHeaders: map[string]string{"Content-Type": "application/x-ndjson"},
+			Raw: `{"description":"Рассказать про базы данных","id":1,"title":"database/sql","updated":"rvasily"}` + "\n" +
+				`{"description":"Рассказать про мемкеш с примером использования","id":2,"title":"memcache","updated":null}` + "\n",
+		},
+		Case{
+			Path:        "/items",
+			Query:       "format=csv&null=NULL",
+			RespHeaders: map[string]string{"Content-Type": "text/csv; charset=utf-8; header=present"},
+			Raw: "id,title,description,updated\r\n" +
+				"1,database/sql,Рассказать про базы данных,rvasily\r\n" +
+				"2,memcache,Рассказать про мемкеш с примером использования,NULL\r\n",
+		},
+		Case{
+			Path:        "/items/_aggregate",
+			Query:       "count=*&format=csv",
+			RespHeaders: map[string]string{"Content-Type": "text/csv; charset=utf-8; header=present"},
+			Raw:         "count\r\n2\r\n",
+		},
+		Case{
+			Path:   "/items",
+			Query:  "format=xml",
+			Status: http.StatusBadRequest,
+			Result: problem(http.StatusBadRequest, "invalid_param", "format", "format must be one of json, ndjson or csv"),
+		},
+		// импорт - только для роли admin; ни один из случаев не меняет items
+		Case{
+			Path:    "/items/_import",
+			Method:  http.MethodPost,
+			Headers: map[string]string{"Content-Type": "application/x-ndjson"},
+			RawBody: `{"id": 1, "title": "x", "description": "y"}` + "\n",
+			Status:  http.StatusUnauthorized,
+			Result:  problem(http.StatusUnauthorized, "unauthorized", "", "valid API key is required"),
+		},
+		Case{
+			Path:    "/items/_import",
+			Method:  http.MethodPost,
+			Headers: map[string]string{"X-API-Key": testAdminKey, "Content-Type": "application/x-ndjson"},
+			RawBody: `{"id": 1, "title": "x", "description": "y"}` + "\n",
+			Result: CR{
+				"response": CR{
+					"mode":     "insert",
+					"lines":    1,
+					"imported": 0,
+					"failed":   1,
+					"aborted":  false,
+					"errors": []CR{
+						CR{"line": 1, "field": "PRIMARY", "code": "duplicate", "detail": "duplicate value '1' for key PRIMARY"},
+					},
+				},
+			},
+		},
+		Case{
+			Path:    "/items/_import",
+			Query:   "mode=upsert",
+			Method:  http.MethodPost,
+			Headers: map[string]string{"X-API-Key": testAdminKey, "Content-Type": "text/csv"},
+			RawBody: "id,title,description,updated\r\n" +
+				"2,memcache,Рассказать про мемкеш с примером использования,\\N\r\n",
+			Result: CR{
+				"response": CR{
+					"mode":     "upsert",
+					"lines":    1,
+					"imported": 1,
+					"failed":   0,
+					"aborted":  false,
+					"errors":   []CR{},
+				},
+			},
+		},
+		Case{
+			Path: "/items/2",
+			Result: CR{
+				"response": CR{
+					"record": CR{
+						"id":          2,
+						"title":       "memcache",
+						"description": "Рассказать про мемкеш с примером использования",
+						"updated":     nil,
+					},
+				},
+			},
+		},
+		// пустой replace не очищает таблицу
+		Case{
+			Path:    "/items/_import",
+			Query:   "mode=replace",
+			Method:  http.MethodPost,
+			Headers: map[string]string{"X-API-Key": testAdminKey, "Content-Type": "application/x-ndjson"},
+			RawBody: "\n",
+			Status:  http.StatusBadRequest,
+			Result: problem(http.StatusBadRequest, "invalid_body", "",
+				"replace needs at least one valid line, the table is left as is"),
+		},
+		Case{
+			Path:  "/items",
+			Query: "limit=1&offset=1",
 			Result: CR{
-				"error": "unknown table",
+				"response": CR{
+					"records": []CR{
+						CR{
+							"id":          2,
+							"title":       "memcache",
+							"description": "Рассказать про мемкеш с примером использования",
+							"updated":     nil,
+						},
+					},
+				},
 			},
 		},
 		Case{
@@ -193,9 +605,7 @@ func TestApis(t *testing.T) {
 		Case{
 			Path:   "/items/100500",
 			Status: http.StatusNotFound,
-			Result: CR{
-				"error": "record not found",
-			},
+			Result: problem(http.StatusNotFound, "not_found", "", "record not found"),
 		},
 
 		// тут идёт создание и редактирование
@@ -317,9 +727,8 @@ func TestApis(t *testing.T) {
 			Body: CR{
 				"id": 4, // primary key нельзя обновлять у существующей записи
 			},
-			Result: CR{
-				"error": "field id have invalid type",
-			},
+			Result: problem(http.StatusBadRequest, "read_only_field", "id",
+				"field id is a primary key and can not be updated"),
 		},
 		Case{
 			Path:   "/items/3",
@@ -328,9 +737,8 @@ func TestApis(t *testing.T) {
 			Body: CR{
 				"title": 42,
 			},
-			Result: CR{
-				"error": "field title have invalid type",
-			},
+			Result: problem(http.StatusBadRequest, "invalid_type", "title",
+				"field title must be varchar(255), got number"),
 		},
 		Case{
 			Path:   "/items/3",
@@ -339,9 +747,7 @@ func TestApis(t *testing.T) {
 			Body: CR{
 				"title": nil,
 			},
-			Result: CR{
-				"error": "field title have invalid type",
-			},
+			Result: problem(http.StatusBadRequest, "not_null", "title", "field title can not be null"),
 		},
 
 		Case{
@@ -351,9 +757,8 @@ func TestApis(t *testing.T) {
 			Body: CR{
 				"updated": 42,
 			},
-			Result: CR{
-				"error": "field updated have invalid type",
-			},
+			Result: problem(http.StatusBadRequest, "invalid_type", "updated",
+				"field updated must be varchar(255), got number"),
 		},
 
 		// удаление
@@ -378,9 +783,7 @@ func TestApis(t *testing.T) {
 		Case{
 			Path:   "/items/3",
 			Status: http.StatusNotFound,
-			Result: CR{
-				"error": "record not found",
-			},
+			Result: problem(http.StatusNotFound, "not_found", "", "record not found"),
 		},
 
 		// и немного по другой таблице
@@ -436,8 +839,47 @@ func TestApis(t *testing.T) {
 			Body: CR{
 				"user_id": 1, // primary key нельзя обновлять у существующей записи
 			},
+			Result: problem(http.StatusBadRequest, "read_only_field", "user_id",
+				"field user_id is a primary key and can not be updated"),
+		},
+		// журнал аудита: образы до и после, время и request_id в runCases убираем
+		Case{
+			Path:   "/_audit",
+			Query:  "table=users&key=1",
+			Status: http.StatusUnauthorized,
+			Result: problem(http.StatusUnauthorized, "unauthorized", "", "valid API key is required"),
+		},
+		Case{
+			Path:    "/_audit",
+			Query:   "table=users&key=1",
+			Headers: map[string]string{"X-API-Key": testAdminKey},
 			Result: CR{
-				"error": "field user_id have invalid type",
+				"response": CR{
+					"entries": []CR{
+						CR{
+							"principal": "127.0.0.1",
+							"table":     "users",
+							"key":       "1",
+							"operation": "update",
+							"before": CR{
+								"user_id":  1,
+								"login":    "rvasily",
+								"password": "love",
+								"email":    "rvasily@example.com",
+								"info":     "none",
+								"updated":  nil,
+							},
+							"after": CR{
+								"user_id":  1,
+								"login":    "rvasily",
+								"password": "love",
+								"email":    "rvasily@example.com",
+								"info":     "try update",
+								"updated":  "now",
+							},
+						},
+					},
+				},
 			},
 		},
 		// не забываем про sql-инъекции
@@ -504,6 +946,24 @@ func TestApis(t *testing.T) {
 	runCases(t, ts, db, cases)
 }
 
+// Ожидаемая ошибка в формате problem+json. request_id сверяется с заголовком в runCases
+func problem(status int, code, field, detail string) CR {
+
+	p := CR{
+		"type":   "about:blank",
+		"title":  http.StatusText(status),
+		"status": status,
+		"code":   code,
+		"detail": detail,
+	}
+
+	if field != "" {
+		p["field"] = field
+	}
+
+	return p
+}
+
 func runCases(t *testing.T, ts *httptest.Server, db *sql.DB, cases []Case) {
 	for idx, item := range cases {
 		var (
@@ -531,6 +991,9 @@ func runCases(t *testing.T, ts *httptest.Server, db *sql.DB, cases []Case) {
 			if errMarshal != nil {
 				panic(errMarshal)
 			}
+			if item.RawBody != "" {
+				data = []byte(item.RawBody)
+			}
 			reqBody := bytes.NewReader(data)
 			var errNewReq error
 			req, errNewReq = http.NewRequest(item.Method, ts.URL+item.Path, reqBody)
@@ -540,6 +1003,10 @@ func runCases(t *testing.T, ts *httptest.Server, db *sql.DB, cases []Case) {
 			req.Header.Add("Content-Type", "application/json")
 		}
 
+		for name, value := range item.Headers {
+			req.Header.Set(name, value)
+		}
+
 		resp, err := client.Do(req)
 		if err != nil {
 			t.Fatalf("[%s] request error: %v", caseName, err)
@@ -562,12 +1029,48 @@ func runCases(t *testing.T, ts *httptest.Server, db *sql.DB, cases []Case) {
 			continue
 		}
 
+		for name, value := range item.RespHeaders {
+			if got := resp.Header.Get(name); got != value {
+				t.Fatalf("[%s] expected header %s %q, got %q", caseName, name, value, got)
+			}
+		}
+
+		if item.Raw != "" {
+			if string(body) != item.Raw {
+				t.Fatalf("[%s] results not match\nGot : %q\nWant: %q", caseName, body, item.Raw)
+			}
+			continue
+		}
+
 		err = json.Unmarshal(body, &result)
 		if err != nil {
 			t.Fatalf("[%s] cant unpack json: %v", caseName, err)
 			continue
 		}
 
+		if resp.Header.Get("Content-Type") == ProblemContentType {
+			p := result.(map[string]interface{})
+			if p["request_id"] != resp.Header.Get(RequestIDHeader) {
+				t.Fatalf("[%s] request_id %v does not match header %q", caseName, p["request_id"], resp.Header.Get(RequestIDHeader))
+			}
+			delete(p, "request_id")
+		}
+
+		// контрольная сумма и время загрузки схемы зависят от сервера
+		if item.Path == "/_admin/reload" && resp.StatusCode == http.StatusOK {
+			delete(result.(map[string]interface{})["response"].(map[string]interface{}), "checksum")
+			delete(result.(map[string]interface{})["response"].(map[string]interface{}), "loaded_at")
+		}
+
+		// время и request_id записей журнала от запуска к запуску разные
+		if item.Path == "/_audit" && resp.StatusCode == http.StatusOK {
+			entries := result.(map[string]interface{})["response"].(map[string]interface{})["entries"].([]interface{})
+			for _, entry := range entries {
+				delete(entry.(map[string]interface{}), "time")
+				delete(entry.(map[string]interface{}), "request_id")
+			}
+		}
+
 		// reflect.DeepEqual не работает если нам приходят разные типы
 		// а там приходят разные типы (string VS interface{}) по сравнению с тем что в ожидаемом результате
 		// этот маленький грязный хак конвертит данные сначала в json, а потом обратно в interface - получаем совместимые результаты
@@ -0,0 +1,375 @@
+package dbexplorer
+
+import (
+	"bufio"
+	"database/sql"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Границы корзин гистограмм длительности, в секундах
+var defaultDurationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Одна временная серия метрики
+type series struct {
+	labels  []string
+	value   float64
+	buckets []uint64
+	sum     float64
+	count   uint64
+}
+
+// Метрика с набором меток: counter или histogram
+type metricVec struct {
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+func newCounterVec(name, help string, labels ...string) *metricVec {
+	return &metricVec{name: name, help: help, kind: "counter", labels: labels, series: make(map[string]*series)}
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *metricVec {
+	return &metricVec{name: name, help: help, kind: "histogram", labels: labels,
+		buckets: buckets, series: make(map[string]*series)}
+}
+
+func (m *metricVec) get(values []string) *series {
+
+	key := strings.Join(values, "\xff")
+
+	s, ok := m.series[key]
+
+	if !ok {
+		s = &series{labels: append([]string(nil), values...)}
+
+		if m.kind == "histogram" {
+			s.buckets = make([]uint64, len(m.buckets))
+		}
+
+		m.series[key] = s
+	}
+
+	return s
+}
+
+// Увеличиваем счетчик
+func (m *metricVec) Add(delta float64, values ...string) {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.get(values).value += delta
+}
+
+// Добавляем наблюдение в гистограмму
+func (m *metricVec) Observe(value float64, values ...string) {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s := m.get(values)
+
+	for i, bound := range m.buckets {
+		if value <= bound {
+			s.buckets[i]++
+		}
+	}
+
+	s.sum += value
+	s.count++
+}
+
+// Пишем метрику в текстовом формате Prometheus
+func (m *metricVec) write(w io.Writer) {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+
+	keys := make([]string, 0, len(m.series))
+
+	for key := range m.series {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+
+		s := m.series[key]
+
+		if m.kind == "counter" {
+			fmt.Fprintf(w, "%s%s %s\n", m.name, formatLabels(m.labels, s.labels), formatFloat(s.value))
+			continue
+		}
+
+		names := append(append([]string(nil), m.labels...), "le")
+		values := append(append([]string(nil), s.labels...), "")
+
+		for i, bound := range m.buckets {
+			values[len(values)-1] = formatFloat(bound)
+			fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, formatLabels(names, values), s.buckets[i])
+		}
+
+		values[len(values)-1] = "+Inf"
+		fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, formatLabels(names, values), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", m.name, formatLabels(m.labels, s.labels), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", m.name, formatLabels(m.labels, s.labels), s.count)
+	}
+}
+
+func writeGauge(w io.Writer, name, help string, value float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", name, help, name, name, formatFloat(value))
+}
+
+func formatLabels(names, values []string) string {
+
+	if len(names) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, len(names))
+
+	for i, name := range names {
+		pairs[i] = fmt.Sprintf(`%s="%s"`, name, escapeLabel(values[i]))
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func formatFloat(value float64) string {
+
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// Метрики сервиса
+type Metrics struct {
+	Requests        *metricVec
+	RequestDuration *metricVec
+	QueryDuration   *metricVec
+	QueryErrors     *metricVec
+	QueryRetries    *metricVec
+	RowsReturned    *metricVec
+	RowsAffected    *metricVec
+
+	db *sql.DB
+}
+
+func NewMetrics(db *sql.DB) *Metrics {
+	return &Metrics{
+		Requests: newCounterVec("db_explorer_http_requests_total",
+			"HTTP requests by table, operation and status.", "table", "operation", "status"),
+		RequestDuration: newHistogramVec("db_explorer_http_request_duration_seconds",
+			"HTTP request latency.", defaultDurationBuckets, "table", "operation", "status"),
+		QueryDuration: newHistogramVec("db_explorer_sql_query_duration_seconds",
+			"SQL statement duration.", defaultDurationBuckets, "table", "operation"),
+		QueryErrors: newCounterVec("db_explorer_sql_errors_total",
+			"Failed SQL statements.", "table", "operation"),
+		QueryRetries: newCounterVec("db_explorer_sql_retries_total",
+			"SQL statements retried after a deadlock or lock wait timeout.", "table", "operation"),
+		RowsReturned: newCounterVec("db_explorer_sql_rows_returned_total",
+			"Rows read from the database.", "table", "operation"),
+		RowsAffected: newCounterVec("db_explorer_sql_rows_affected_total",
+			"Rows changed in the database.", "table", "operation"),
+		db: db,
+	}
+}
+
+// Пишем все метрики в текстовом формате Prometheus
+func (m *Metrics) WriteTo(out io.Writer) (int64, error) {
+
+	buf := bufio.NewWriter(out)
+	w := &countingWriter{w: buf}
+
+	for _, vec := range []*metricVec{m.Requests, m.RequestDuration, m.QueryDuration,
+		m.QueryErrors, m.QueryRetries, m.RowsReturned, m.RowsAffected} {
+		vec.write(w)
+	}
+
+	if m.db != nil {
+
+		stats := m.db.Stats()
+
+		writeGauge(w, "db_explorer_db_max_open_connections", "Maximum number of open connections.",
+			float64(stats.MaxOpenConnections))
+		writeGauge(w, "db_explorer_db_open_connections", "Established connections.",
+			float64(stats.OpenConnections))
+		writeGauge(w, "db_explorer_db_in_use_connections", "Connections currently in use.",
+			float64(stats.InUse))
+		writeGauge(w, "db_explorer_db_idle_connections", "Idle connections.",
+			float64(stats.Idle))
+
+		fmt.Fprintf(w, "# HELP %[1]s Connections waited for.\n# TYPE %[1]s counter\n%[1]s %d\n",
+			"db_explorer_db_wait_count_total", stats.WaitCount)
+		fmt.Fprintf(w, "# HELP %[1]s Time blocked waiting for a connection.\n# TYPE %[1]s counter\n%[1]s %s\n",
+			"db_explorer_db_wait_duration_seconds_total", formatFloat(stats.WaitDuration.Seconds()))
+	}
+
+	return w.n, buf.Flush()
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Хендлер метрик в формате Prometheus. Вызывается по эндпоинту "/metrics" [GET]
+func (h *Handler) MetricsHandler(w http.ResponseWriter, r *http.Request) {
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	_, err := h.Metrics.WriteTo(w)
+
+	if err != nil {
+		requestLogger(r).Warn("bad write of metrics", "error", err)
+	}
+}
+
+// Запоминаем статус ответа для метрик и логов
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+
+	if s.status == 0 {
+		s.status = status
+	}
+
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+
+	return s.ResponseWriter.Write(b)
+}
+
+func (s *statusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Для http.ResponseController: импорт снимает таймауты соединения
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// Служебные эндпоинты "/_name", у которых своя операция в метках
+var serviceOperations = map[string]bool{
+	"audit":   true,
+	"stats":   true,
+	"admin":   true,
+	"rpc":     true,
+	"sql":     true,
+	"queries": true,
+}
+
+// Таблица и операция запроса для меток. Неизвестные таблицы не попадают в метки,
+// а неизвестные пути и методы становятся операцией "other", чтобы клиент не мог
+// раздуть число серий
+func (h *Handler) requestLabels(r *http.Request) (string, string) {
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+
+	if parts[0] == "" {
+		return "", "tables"
+	}
+
+	if strings.HasPrefix(parts[0], "_") {
+
+		if name := strings.TrimPrefix(parts[0], "_"); serviceOperations[name] {
+			return "", name
+		}
+
+		return "", "other"
+	}
+
+	table := ""
+
+	if ok, _, _ := contains(h.tables(), parts[0]); ok {
+		table = parts[0]
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		if len(parts) == 1 {
+			return table, "list"
+		}
+		if parts[1] == "_aggregate" {
+			return table, "aggregate"
+		}
+		if parts[1] == "_distinct" {
+			return table, "distinct"
+		}
+		return table, "get"
+	case http.MethodPut:
+		return table, "create"
+	case http.MethodPost:
+		if len(parts) == 2 && parts[1] == "_import" {
+			return table, "import"
+		}
+		return table, "update"
+	case http.MethodDelete:
+		return table, "delete"
+	case http.MethodOptions:
+		return table, "options"
+	}
+
+	return table, "other"
+}
+
+// Считаем запросы и их длительность
+func (h *Handler) instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+
+		next.ServeHTTP(rec, r)
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		table, operation := h.requestLabels(r)
+		status := strconv.Itoa(rec.status)
+
+		h.Metrics.Requests.Add(1, table, operation, status)
+		h.Metrics.RequestDuration.Observe(time.Since(start).Seconds(), table, operation, status)
+	})
+}
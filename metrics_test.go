@@ -0,0 +1,63 @@
+package dbexplorer
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsExposition(t *testing.T) {
+
+	h := &Handler{
+		Metrics: NewMetrics(nil),
+	}
+
+	h.schema.Store(&Schema{Tables: []TableInfo{{Name: "items", ID: "id"}}})
+
+	handler := h.instrument(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/unknown") {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	for _, path := range []string{"/items", "/items", "/unknown_table/1", "/_random1", "/_random2", "/_sql"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	for _, method := range []string{"BREW", "PROPFIND"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, "/items", nil))
+	}
+
+	h.observeQuery(context.Background(), "items", "list", "SELECT 1", nil, time.Now(), nil)
+	h.rowsReturned(context.Background(), "items", "list", 2)
+	h.Metrics.RowsAffected.Add(1, "items", `del"ete`)
+
+	rec := httptest.NewRecorder()
+	h.MetricsHandler(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	body := rec.Body.String()
+
+	expected := []string{
+		"# TYPE db_explorer_http_requests_total counter",
+		`db_explorer_http_requests_total{table="items",operation="list",status="200"} 2`,
+		`db_explorer_http_requests_total{table="",operation="get",status="404"} 1`,
+		`db_explorer_http_requests_total{table="",operation="other",status="200"} 2`,
+		`db_explorer_http_requests_total{table="",operation="sql",status="200"} 1`,
+		`db_explorer_http_requests_total{table="items",operation="other",status="200"} 2`,
+		"# TYPE db_explorer_http_request_duration_seconds histogram",
+		`db_explorer_http_request_duration_seconds_bucket{table="items",operation="list",status="200",le="+Inf"} 2`,
+		`db_explorer_http_request_duration_seconds_count{table="items",operation="list",status="200"} 2`,
+		`db_explorer_sql_query_duration_seconds_count{table="items",operation="list"} 1`,
+		`db_explorer_sql_rows_returned_total{table="items",operation="list"} 2`,
+		`db_explorer_sql_rows_affected_total{table="items",operation="del\"ete"} 1`,
+	}
+
+	for _, line := range expected {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("missing %q in\n%s", line, body)
+		}
+	}
+}
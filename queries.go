@@ -0,0 +1,333 @@
+package dbexplorer
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Типы параметров сохраненных запросов
+const (
+	ParamString = "string"
+	ParamInt    = "int"
+	ParamFloat  = "float"
+	ParamBool   = "bool"
+)
+
+// Сохраненный запрос из настроек. Параметры в тексте пишутся как :name.
+// В YAML можно задать просто строкой SQL, тогда все параметры - строки
+type SavedQuery struct {
+	SQL    string                `yaml:"sql"`
+	Params map[string]QueryParam `yaml:"params"`
+}
+
+// Параметр сохраненного запроса
+type QueryParam struct {
+	// string, int, float или bool. Пусто - string
+	Type string `yaml:"type" json:"type"`
+	// Значение, если параметр не передан. Пусто - параметр обязателен
+	Default string `yaml:"default" json:"default,omitempty"`
+}
+
+func (q *SavedQuery) UnmarshalYAML(node *yaml.Node) error {
+
+	if node.Kind == yaml.ScalarNode {
+		q.SQL = node.Value
+		return nil
+	}
+
+	type plain SavedQuery
+
+	return node.Decode((*plain)(q))
+}
+
+// Прерывает чтение результата сохраненного запроса на sql.max_rows строках
+var errRowLimit = errors.New("row limit reached")
+
+// Сохраненный запрос, готовый к выполнению: :name заменены на ?
+type compiledQuery struct {
+	Name   string
+	SQL    string
+	Params map[string]QueryParam
+	// Имена параметров в порядке знаков ? в SQL
+	Order []string
+}
+
+// Проверяем запрос и заменяем :name на ? вне строк и комментариев
+func compileQuery(name string, q SavedQuery) (*compiledQuery, error) {
+
+	if err := checkReadOnlySQL(q.SQL); err != nil {
+		return nil, fmt.Errorf("query %s: %v", name, err)
+	}
+
+	compiled := &compiledQuery{Name: name, Params: make(map[string]QueryParam)}
+
+	var sqlText strings.Builder
+
+	for i := 0; i < len(q.SQL); {
+
+		c := q.SQL[i]
+
+		switch {
+
+		case c == '#' || c == '-' && isLineComment(q.SQL[i:]):
+			end := strings.IndexByte(q.SQL[i:], '\n')
+			if end < 0 {
+				end = len(q.SQL) - i - 1
+			}
+			sqlText.WriteString(q.SQL[i : i+end+1])
+			i += end + 1
+
+		case c == '/' && strings.HasPrefix(q.SQL[i:], "/*"):
+			// незакрытый комментарий уже отсеяла checkReadOnlySQL
+			end := strings.Index(q.SQL[i+2:], "*/") + i + 4
+			sqlText.WriteString(q.SQL[i:end])
+			i = end
+
+		case c == '\'' || c == '"' || c == '`':
+			end, err := quotedEnd(q.SQL, i)
+			if err != nil {
+				return nil, fmt.Errorf("query %s: %v", name, err)
+			}
+			sqlText.WriteString(q.SQL[i:end])
+			i = end
+
+		case c == ':' && i+1 < len(q.SQL) && isWordByte(q.SQL[i+1]):
+			start := i + 1
+			i = start
+			for i < len(q.SQL) && isWordByte(q.SQL[i]) {
+				i++
+			}
+			compiled.Order = append(compiled.Order, q.SQL[start:i])
+			sqlText.WriteByte('?')
+
+		default:
+			sqlText.WriteByte(c)
+			i++
+		}
+	}
+
+	compiled.SQL = sqlText.String()
+
+	for _, param := range compiled.Order {
+		compiled.Params[param] = QueryParam{Type: ParamString}
+	}
+
+	for param, info := range q.Params {
+
+		if _, ok := compiled.Params[param]; !ok {
+			return nil, fmt.Errorf("query %s: parameter %s is not used", name, param)
+		}
+
+		if info.Type == "" {
+			info.Type = ParamString
+		}
+
+		switch info.Type {
+		case ParamString, ParamInt, ParamFloat, ParamBool:
+		default:
+			return nil, fmt.Errorf("query %s: parameter %s has unknown type %s", name, param, info.Type)
+		}
+
+		if info.Default != "" {
+			if _, err := parseParam(info, info.Default); err != nil {
+				return nil, fmt.Errorf("query %s: bad default of %s: %v", name, param, err)
+			}
+		}
+
+		compiled.Params[param] = info
+	}
+
+	return compiled, nil
+}
+
+func compileQueries(queries map[string]SavedQuery) (map[string]*compiledQuery, error) {
+
+	compiled := make(map[string]*compiledQuery, len(queries))
+
+	for name, q := range queries {
+
+		c, err := compileQuery(name, q)
+
+		if err != nil {
+			return nil, err
+		}
+
+		compiled[name] = c
+	}
+
+	return compiled, nil
+}
+
+// Разбираем значение параметра из строки запроса по его типу
+func parseParam(param QueryParam, value string) (interface{}, error) {
+
+	switch param.Type {
+	case ParamInt:
+		return strconv.ParseInt(value, 10, 64)
+	case ParamFloat:
+		return strconv.ParseFloat(value, 64)
+	case ParamBool:
+		return strconv.ParseBool(value)
+	}
+
+	return value, nil
+}
+
+// Аргументы запроса из параметров URL
+func (q *compiledQuery) args(r *http.Request) ([]interface{}, error) {
+
+	query := r.URL.Query()
+
+	for name := range query {
+		if _, ok := q.Params[name]; !ok {
+			return nil, FieldProblem(http.StatusBadRequest, CodeUnknownField, name,
+				fmt.Sprintf("query %s has no parameter %s", q.Name, name))
+		}
+	}
+
+	values := make(map[string]interface{}, len(q.Params))
+
+	for name, param := range q.Params {
+
+		value := query.Get(name)
+
+		if !query.Has(name) {
+			value = param.Default
+		}
+
+		if !query.Has(name) && value == "" {
+			return nil, FieldProblem(http.StatusBadRequest, CodeInvalidParam, name,
+				fmt.Sprintf("parameter %s is required", name))
+		}
+
+		parsed, err := parseParam(param, value)
+
+		if err != nil {
+			return nil, FieldProblem(http.StatusBadRequest, CodeInvalidType, name,
+				fmt.Sprintf("parameter %s must be %s", name, param.Type))
+		}
+
+		values[name] = parsed
+	}
+
+	args := make([]interface{}, len(q.Order))
+
+	for i, name := range q.Order {
+		args[i] = values[name]
+	}
+
+	return args, nil
+}
+
+// Хендлер для сохраненных запросов: список по "/_queries" и выполнение
+// по "/_queries/{name}?param=value" [GET]
+func (h *Handler) SavedQueries(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed,
+			fmt.Sprintf("method %s is not allowed", r.Method))
+		return
+	}
+
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/_queries"), "/")
+
+	if name == "" {
+		h.listSavedQueries(w, r)
+		return
+	}
+
+	q, ok := h.queries[name]
+
+	if !ok {
+		writeError(w, r, http.StatusNotFound, CodeNotFound, fmt.Sprintf("unknown query %s", name))
+		return
+	}
+
+	args, err := q.args(r)
+
+	if err != nil {
+		writeProblem(w, r, err.(*Problem))
+		return
+	}
+
+	ctx, cancel := h.operationContext(r.Context(), "query")
+	defer cancel()
+
+	rows, err := h.query(ctx, name, "query", q.SQL, args...)
+
+	if err != nil {
+		h.dbError(ctx, w, r, err, "bad saved query", "query", name)
+		return
+	}
+
+	defer rows.Close()
+
+	records := make([]map[string]interface{}, 0)
+	truncated := false
+
+	// держим в памяти не больше sql.max_rows строк, остальные отбрасываем
+	err = scanEach(rows, func(record map[string]interface{}) error {
+
+		if len(records) == h.Config.SQL.MaxRows {
+			truncated = true
+			// иначе драйвер дочитает весь результат при закрытии
+			cancel()
+			return errRowLimit
+		}
+
+		records = append(records, record)
+
+		return nil
+	})
+
+	if err == errRowLimit {
+		err = nil
+	}
+
+	if err != nil {
+		h.dbError(ctx, w, r, err, "bad saved query", "query", name)
+		return
+	}
+
+	h.rowsReturned(r.Context(), name, "query", len(records))
+
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"response": map[string]interface{}{
+			"records":   records,
+			"truncated": truncated,
+		},
+	})
+}
+
+func (h *Handler) listSavedQueries(w http.ResponseWriter, r *http.Request) {
+
+	names := make([]string, 0, len(h.queries))
+
+	for name := range h.queries {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	queries := make([]map[string]interface{}, 0, len(names))
+
+	for _, name := range names {
+		queries = append(queries, map[string]interface{}{
+			"name":   name,
+			"params": h.queries[name].Params,
+		})
+	}
+
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"response": map[string]interface{}{
+			"queries": queries,
+		},
+	})
+}
@@ -0,0 +1,98 @@
+package dbexplorer
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestCompileQuery(t *testing.T) {
+
+	q, err := compileQuery("top", SavedQuery{
+		SQL: "SELECT id FROM items WHERE updated = :who AND title <> ':skip' -- :comment\nLIMIT :n",
+		Params: map[string]QueryParam{
+			"n": {Type: ParamInt, Default: "10"},
+		},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantSQL := "SELECT id FROM items WHERE updated = ? AND title <> ':skip' -- :comment\nLIMIT ?"
+
+	if q.SQL != wantSQL || !reflect.DeepEqual(q.Order, []string{"who", "n"}) {
+		t.Errorf("got %q %v", q.SQL, q.Order)
+	}
+
+	if q.Params["who"].Type != ParamString {
+		t.Errorf("undeclared parameter must be a string, got %q", q.Params["who"].Type)
+	}
+
+	bad := []SavedQuery{
+		{SQL: "DELETE FROM items WHERE id = :id"},
+		{SQL: "SELECT 1", Params: map[string]QueryParam{"id": {}}},
+		{SQL: "SELECT :id", Params: map[string]QueryParam{"id": {Type: "date"}}},
+		{SQL: "SELECT :id", Params: map[string]QueryParam{"id": {Type: ParamInt, Default: "x"}}},
+	}
+
+	for _, b := range bad {
+		if _, err := compileQuery("bad", b); err == nil {
+			t.Errorf("%q: expected error", b.SQL)
+		}
+	}
+}
+
+func TestSavedQueryArgs(t *testing.T) {
+
+	q, err := compileQuery("top", SavedQuery{
+		SQL:    "SELECT id FROM items WHERE updated = :who OR :who = '' LIMIT :n",
+		Params: map[string]QueryParam{"n": {Type: ParamInt, Default: "10"}},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	args, err := q.args(httptest.NewRequest("GET", "/_queries/top?who=bob", nil))
+
+	if err != nil || !reflect.DeepEqual(args, []interface{}{"bob", "bob", int64(10)}) {
+		t.Errorf("got %#v, %v", args, err)
+	}
+
+	cases := map[string]string{
+		"/_queries/top?who=bob&n=ten":  "n",
+		"/_queries/top?n=1":            "who",
+		"/_queries/top?who=bob&page=1": "page",
+	}
+
+	for url, field := range cases {
+		_, err := q.args(httptest.NewRequest("GET", url, nil))
+		if p, ok := err.(*Problem); !ok || p.Field != field {
+			t.Errorf("%s: got %v, want problem for %s", url, err, field)
+		}
+	}
+}
+
+func TestSavedQueryYAML(t *testing.T) {
+
+	var queries map[string]SavedQuery
+
+	err := yaml.Unmarshal([]byte(`
+short: SELECT :a
+long:
+  sql: SELECT :b
+  params:
+    b: {type: int}
+`), &queries)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if queries["short"].SQL != "SELECT :a" || queries["long"].Params["b"].Type != ParamInt {
+		t.Errorf("got %+v", queries)
+	}
+}
@@ -0,0 +1,209 @@
+package dbexplorer
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Все запросы хендлеров к БД идут через эти обертки, чтобы метрики, лог SQL
+// и спаны собирались в одном месте. table и operation - метки метрик
+
+// Пул или одно соединение: запросам, которые держат состояние сессии, нужен *sql.Conn
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// Выполняем SELECT и возвращаем строки. Количество прочитанных строк
+// вызывающий сообщает сам через rowsReturned
+func (h *Handler) query(ctx context.Context, table, operation, query string, args ...interface{}) (*sql.Rows, error) {
+
+	ctx, span := h.startQuerySpan(ctx, table, operation, query)
+	defer span.Finish()
+
+	var rows *sql.Rows
+
+	err := h.retry(ctx, table, operation, func() error {
+
+		start := time.Now()
+
+		var err error
+		rows, err = h.DB.QueryContext(ctx, query, args...)
+
+		h.observeQuery(ctx, table, operation, query, args, start, err)
+
+		return err
+	})
+
+	span.SetError(err)
+
+	return rows, err
+}
+
+// Выполняем запрос на конкретном соединении без повторов: вызывающий сам решает,
+// безопасно ли повторять
+func (h *Handler) queryOn(ctx context.Context, db querier, table, operation, query string,
+	args ...interface{}) (*sql.Rows, error) {
+
+	ctx, span := h.startQuerySpan(ctx, table, operation, query)
+	defer span.Finish()
+
+	start := time.Now()
+
+	rows, err := db.QueryContext(ctx, query, args...)
+
+	h.observeQuery(ctx, table, operation, query, args, start, err)
+	span.SetError(err)
+
+	return rows, err
+}
+
+// Изменяющий запрос на конкретном соединении без повторов
+func (h *Handler) execOn(ctx context.Context, db querier, table, operation, query string,
+	args ...interface{}) (sql.Result, error) {
+
+	ctx, span := h.startQuerySpan(ctx, table, operation, query)
+	defer span.Finish()
+
+	start := time.Now()
+
+	res, err := db.ExecContext(ctx, query, args...)
+
+	h.observeQuery(ctx, table, operation, query, args, start, err)
+	span.SetError(err)
+
+	return res, err
+}
+
+// Выполняем SELECT одной строки и сразу читаем ее в dest
+func (h *Handler) queryRow(ctx context.Context, table, operation string, dest []interface{},
+	query string, args ...interface{}) error {
+
+	ctx, span := h.startQuerySpan(ctx, table, operation, query)
+	defer span.Finish()
+
+	err := h.retry(ctx, table, operation, func() error {
+
+		start := time.Now()
+
+		err := h.DB.QueryRowContext(ctx, query, args...).Scan(dest...)
+
+		if err == sql.ErrNoRows {
+			h.observeQuery(ctx, table, operation, query, args, start, nil)
+			return err
+		}
+
+		h.observeQuery(ctx, table, operation, query, args, start, err)
+
+		return err
+	})
+
+	if err == sql.ErrNoRows {
+		span.SetAttr("db.rows_returned", 0)
+		return err
+	}
+
+	span.SetError(err)
+
+	if err == nil {
+		span.SetAttr("db.rows_returned", 1)
+		h.rowsReturned(ctx, table, operation, 1)
+	}
+
+	return err
+}
+
+// Выполняем изменяющий запрос
+func (h *Handler) exec(ctx context.Context, table, operation, query string, args ...interface{}) (sql.Result, error) {
+
+	ctx, span := h.startQuerySpan(ctx, table, operation, query)
+	defer span.Finish()
+
+	var res sql.Result
+
+	err := h.retry(ctx, table, operation, func() error {
+
+		start := time.Now()
+
+		var err error
+		res, err = h.DB.ExecContext(ctx, query, args...)
+
+		h.observeQuery(ctx, table, operation, query, args, start, err)
+
+		return err
+	})
+
+	span.SetError(err)
+
+	if err != nil {
+		return res, err
+	}
+
+	if affected, errAffected := res.RowsAffected(); errAffected == nil {
+
+		span.SetAttr("db.rows_affected", affected)
+
+		if h.Metrics != nil {
+			h.Metrics.RowsAffected.Add(float64(affected), table, operation)
+		}
+	}
+
+	return res, err
+}
+
+func (h *Handler) observeQuery(ctx context.Context, table, operation, query string, args []interface{},
+	start time.Time, err error) {
+
+	duration := time.Since(start)
+
+	if h.Config.Log.SQL {
+
+		attrs := []interface{}{"table", table, "operation", operation, "query", query, "duration", duration}
+
+		if h.Config.Log.Payloads {
+			attrs = append(attrs, "args", args)
+		}
+
+		if err != nil {
+			attrs = append(attrs, "error", err)
+		}
+
+		loggerFrom(ctx).Info("sql", attrs...)
+	}
+
+	if h.Metrics == nil {
+		return
+	}
+
+	h.Metrics.QueryDuration.Observe(duration.Seconds(), table, operation)
+
+	if err != nil {
+		h.Metrics.QueryErrors.Add(1, table, operation)
+	}
+}
+
+// Спан SQL-запроса - дочерний к спану HTTP-запроса
+func (h *Handler) startQuerySpan(ctx context.Context, table, operation, query string) (context.Context, *Span) {
+
+	ctx, span := startSpan(ctx, "sql "+operation)
+
+	span.SetAttr("db.system", "mysql")
+	span.SetAttr("db.table", table)
+	span.SetAttr("db.operation", operation)
+	span.SetAttr("db.statement", query)
+
+	return ctx, span
+}
+
+// Сколько строк прочитано. Число попадает в метрики и в спан HTTP-запроса
+func (h *Handler) rowsReturned(ctx context.Context, table, operation string, n int) {
+
+	SpanFromContext(ctx).AddAttr("db.rows_returned", int64(n))
+
+	if h.Metrics == nil {
+		return
+	}
+
+	h.Metrics.RowsReturned.Add(float64(n), table, operation)
+}
@@ -0,0 +1,239 @@
+package dbexplorer
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Ограничение скорости token bucket: Rate запросов в секунду с запасом Burst.
+// Rate = 0 - без ограничения
+type RateLimit struct {
+	Rate  float64 `yaml:"rate"`
+	Burst int     `yaml:"burst"`
+}
+
+// Квоты для отдельной таблицы. Заменяют общие квоты целиком
+type TableRateLimit struct {
+	Read          RateLimit `yaml:"read"`
+	Write         RateLimit `yaml:"write"`
+	MaxConcurrent int       `yaml:"max_concurrent"`
+}
+
+// Настройки ограничения запросов от одного клиента
+type RateLimitConfig struct {
+	// Бюджет на чтение (GET) и на запись (PUT, POST, DELETE)
+	Read  RateLimit `yaml:"read"`
+	Write RateLimit `yaml:"write"`
+	// Сколько запросов клиента одновременно может работать с БД. 0 - без ограничения
+	MaxConcurrent int `yaml:"max_concurrent"`
+	// Квоты по таблицам
+	Tables map[string]TableRateLimit `yaml:"tables"`
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Ограничитель запросов. Ведра и счетчики живут по ключу "клиент|таблица|вид"
+type RateLimiter struct {
+	Config RateLimitConfig
+	// Заголовок и известные ключи из auth: квоты по ключу считаем только для них
+	Auth AuthConfig
+
+	mu       sync.Mutex
+	buckets  map[string]*tokenBucket
+	inflight map[string]int
+	now      func() time.Time
+}
+
+// Ведра, которые не трогали дольше, удаляем при очередной очистке
+const bucketIdleTTL = 10 * time.Minute
+
+func NewRateLimiter(cfg RateLimitConfig, auth AuthConfig) *RateLimiter {
+	return &RateLimiter{
+		Config:   cfg,
+		Auth:     auth,
+		buckets:  make(map[string]*tokenBucket),
+		inflight: make(map[string]int),
+		now:      time.Now,
+	}
+}
+
+// Квоты, которые действуют для таблицы, и ключ, по которому они считаются
+func (l *RateLimiter) limits(table string, write bool) (RateLimit, int, string) {
+
+	if tl, ok := l.Config.Tables[table]; ok {
+		if write {
+			return tl.Write, tl.MaxConcurrent, table
+		}
+		return tl.Read, tl.MaxConcurrent, table
+	}
+
+	if write {
+		return l.Config.Write, l.Config.MaxConcurrent, "*"
+	}
+
+	return l.Config.Read, l.Config.MaxConcurrent, "*"
+}
+
+// Забираем токен из ведра. Если токенов нет - возвращаем, через сколько он появится
+func (l *RateLimiter) take(key string, limit RateLimit) (bool, time.Duration) {
+
+	if limit.Rate <= 0 {
+		return true, 0
+	}
+
+	burst := float64(limit.Burst)
+
+	if burst < 1 {
+		burst = 1
+	}
+
+	now := l.now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	bucket, ok := l.buckets[key]
+
+	if !ok {
+		l.sweep(now)
+		bucket = &tokenBucket{tokens: burst, last: now}
+		l.buckets[key] = bucket
+	}
+
+	bucket.tokens = math.Min(burst, bucket.tokens+now.Sub(bucket.last).Seconds()*limit.Rate)
+	bucket.last = now
+
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0
+	}
+
+	wait := time.Duration((1 - bucket.tokens) / limit.Rate * float64(time.Second))
+
+	return false, wait
+}
+
+// Удаляем давно не использованные ведра, чтобы карта не росла бесконечно
+func (l *RateLimiter) sweep(now time.Time) {
+
+	if len(l.buckets) < 1024 {
+		return
+	}
+
+	for key, bucket := range l.buckets {
+		if now.Sub(bucket.last) > bucketIdleTTL {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// Занимаем слот для запроса к БД. Возвращает false, если все слоты клиента заняты
+func (l *RateLimiter) acquire(key string, max int) bool {
+
+	if max <= 0 {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.inflight[key] >= max {
+		return false
+	}
+
+	l.inflight[key]++
+
+	return true
+}
+
+func (l *RateLimiter) release(key string, max int) {
+
+	if max <= 0 {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.inflight[key]--
+
+	if l.inflight[key] <= 0 {
+		delete(l.inflight, key)
+	}
+}
+
+// Клиент запроса: известный API-ключ, иначе IP. Случайный ключ в каждом запросе
+// не должен давать новое полное ведро
+func (l *RateLimiter) client(r *http.Request) string {
+
+	// тот же заголовок, что читает authorize
+	if key := r.Header.Get(l.Auth.KeyHeader); key != "" {
+		if _, ok := l.Auth.Keys[key]; ok {
+			return "key:" + key
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	return "ip:" + host
+}
+
+// Оборачиваем хендлер проверкой квот клиента
+func (l *RateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		table := strings.Split(strings.Trim(r.URL.Path, "/"), "/")[0]
+		write := r.Method != http.MethodGet && r.Method != http.MethodHead
+
+		limit, max, scope := l.limits(table, write)
+		client := l.client(r)
+
+		kind := "read"
+		if write {
+			kind = "write"
+		}
+
+		ok, wait := l.take(client+"|"+scope+"|"+kind, limit)
+
+		if !ok {
+			tooManyRequests(w, r, wait)
+			return
+		}
+
+		slot := client + "|" + scope
+
+		if !l.acquire(slot, max) {
+			tooManyRequests(w, r, time.Second)
+			return
+		}
+
+		defer l.release(slot, max)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func tooManyRequests(w http.ResponseWriter, r *http.Request, wait time.Duration) {
+
+	seconds := int(math.Ceil(wait.Seconds()))
+
+	if seconds < 1 {
+		seconds = 1
+	}
+
+	w.Header().Set("Retry-After", fmt.Sprint(seconds))
+
+	writeError(w, r, http.StatusTooManyRequests, CodeRateLimited, "rate limit exceeded")
+}
@@ -0,0 +1,162 @@
+package dbexplorer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterTokenBucket(t *testing.T) {
+
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	limiter := NewRateLimiter(RateLimitConfig{}, AuthConfig{})
+	limiter.now = func() time.Time { return now }
+
+	limit := RateLimit{Rate: 2, Burst: 2}
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := limiter.take("c", limit); !ok {
+			t.Fatalf("request %d must fit into burst", i)
+		}
+	}
+
+	ok, wait := limiter.take("c", limit)
+	if ok || wait != 500*time.Millisecond {
+		t.Fatalf("expected rejection with 500ms wait, got %v %v", ok, wait)
+	}
+
+	now = now.Add(500 * time.Millisecond)
+
+	if ok, _ := limiter.take("c", limit); !ok {
+		t.Fatal("token must be refilled after 500ms")
+	}
+}
+
+func TestRateLimiterMiddleware(t *testing.T) {
+
+	limiter := NewRateLimiter(RateLimitConfig{
+		Read: RateLimit{Rate: 1, Burst: 1},
+		Tables: map[string]TableRateLimit{
+			"items": {Write: RateLimit{Rate: 1, Burst: 1}},
+		},
+	}, AuthConfig{KeyHeader: "X-API-Key", Keys: map[string][]string{"a": {"reader"}, "b": {"reader"}}})
+
+	handler := limiter.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	do := func(method, path, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("X-API-Key", key)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := do(http.MethodGet, "/users", "a"); rec.Code != http.StatusOK {
+		t.Fatalf("first read: %d", rec.Code)
+	}
+
+	rec := do(http.MethodGet, "/users/1", "a")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "1" {
+		t.Fatalf("second read must be limited: %d %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+
+	if rec := do(http.MethodGet, "/users", "b"); rec.Code != http.StatusOK {
+		t.Fatalf("other client must have its own budget: %d", rec.Code)
+	}
+
+	// для items свои квоты: чтение не ограничено, запись - 1 в секунду
+	for i := 0; i < 3; i++ {
+		if rec := do(http.MethodGet, "/items", "a"); rec.Code != http.StatusOK {
+			t.Fatalf("items read %d: %d", i, rec.Code)
+		}
+	}
+
+	if rec := do(http.MethodPost, "/items/1", "a"); rec.Code != http.StatusOK {
+		t.Fatalf("first write: %d", rec.Code)
+	}
+
+	if rec := do(http.MethodPost, "/items/1", "a"); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("second write must be limited: %d", rec.Code)
+	}
+}
+
+func TestRateLimiterUnknownKeys(t *testing.T) {
+
+	limiter := NewRateLimiter(RateLimitConfig{
+		Read: RateLimit{Rate: 1, Burst: 1},
+	}, AuthConfig{KeyHeader: "X-API-Key", Keys: map[string][]string{"known": {"reader"}}})
+
+	handler := limiter.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	do := func(key string) int {
+		req := httptest.NewRequest(http.MethodGet, "/users", nil)
+		req.Header.Set("X-API-Key", key)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := do("random-1"); code != http.StatusOK {
+		t.Fatalf("first read: %d", code)
+	}
+
+	// неизвестный ключ не дает своего ведра: клиент тот же IP
+	if code := do("random-2"); code != http.StatusTooManyRequests {
+		t.Fatalf("fresh unknown key must not bypass the limit: %d", code)
+	}
+
+	if code := do("known"); code != http.StatusOK {
+		t.Fatalf("known key must have its own budget: %d", code)
+	}
+
+	if len(limiter.buckets) != 2 {
+		t.Fatalf("expected buckets for the IP and the known key, got %d", len(limiter.buckets))
+	}
+}
+
+func TestRateLimiterConcurrency(t *testing.T) {
+
+	limiter := NewRateLimiter(RateLimitConfig{MaxConcurrent: 1}, AuthConfig{})
+
+	if !limiter.acquire("c|*", 1) {
+		t.Fatal("first slot must be free")
+	}
+
+	if limiter.acquire("c|*", 1) {
+		t.Fatal("second slot must be busy")
+	}
+
+	limiter.release("c|*", 1)
+
+	if !limiter.acquire("c|*", 1) {
+		t.Fatal("slot must be free after release")
+	}
+}
+
+func TestRateLimiterAuthHeader(t *testing.T) {
+
+	limiter := NewRateLimiter(RateLimitConfig{
+		Read: RateLimit{Rate: 1, Burst: 1},
+	}, AuthConfig{KeyHeader: "X-Token", Keys: map[string][]string{"known": {"reader"}}})
+
+	handler := limiter.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	do := func(header string) int {
+		req := httptest.NewRequest(http.MethodGet, "/users", nil)
+		req.Header.Set(header, "known")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := do("X-API-Key"); code != http.StatusOK {
+		t.Fatalf("first read by IP: %d", code)
+	}
+
+	// ключ из заголовка auth.key_header - свое ведро, а не ведро IP
+	if code := do("X-Token"); code != http.StatusOK {
+		t.Fatalf("key from the auth header must have its own budget: %d", code)
+	}
+}
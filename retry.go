@@ -0,0 +1,87 @@
+package dbexplorer
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// Настройки повтора запросов при дедлоках и таймаутах блокировок
+type RetryConfig struct {
+	// Сколько всего попыток, включая первую. 1 - без повторов
+	Attempts int `yaml:"attempts"`
+	// Пауза перед первым повтором, дальше удваивается
+	BaseDelay time.Duration `yaml:"base_delay"`
+	// Максимальная пауза между попытками
+	MaxDelay time.Duration `yaml:"max_delay"`
+	// Случайный разброс паузы, доля от 0 до 1
+	Jitter float64 `yaml:"jitter"`
+}
+
+// Ошибка, после которой запрос можно просто повторить: MySQL откатил его целиком
+func isTransient(err error) bool {
+
+	var me *mysql.MySQLError
+
+	if !errors.As(err, &me) {
+		return false
+	}
+
+	return me.Number == mysqlErrDeadlock || me.Number == mysqlErrLockWaitTimeout
+}
+
+// Пауза перед повтором номер attempt (с единицы). rnd возвращает число из [0, 1)
+func (c RetryConfig) backoff(attempt int, rnd func() float64) time.Duration {
+
+	delay := c.BaseDelay << (attempt - 1)
+
+	if delay > c.MaxDelay || delay <= 0 {
+		delay = c.MaxDelay
+	}
+
+	if c.Jitter > 0 {
+		delay += time.Duration(float64(delay) * c.Jitter * (2*rnd() - 1))
+	}
+
+	return delay
+}
+
+// Выполняем fn и повторяем при временных ошибках БД. Каждая попытка - целый запрос,
+// поэтому повторять можно только то, что MySQL откатывает при ошибке: одиночные
+// запросы в autocommit и транзакции целиком. Ожидание прерывается контекстом
+func (h *Handler) retry(ctx context.Context, table, operation string, fn func() error) error {
+
+	cfg := h.Config.Retry
+
+	for attempt := 1; ; attempt++ {
+
+		err := fn()
+
+		if err == nil || attempt >= cfg.Attempts || !isTransient(err) {
+			SpanFromContext(ctx).SetAttr("db.attempts", attempt)
+			return err
+		}
+
+		delay := cfg.backoff(attempt, rand.Float64)
+
+		loggerFrom(ctx).Warn("retrying query", "table", table, "operation", operation,
+			"attempt", attempt, "delay", delay, "error", err)
+
+		if h.Metrics != nil {
+			h.Metrics.QueryRetries.Add(1, table, operation)
+		}
+
+		timer := time.NewTimer(delay)
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			SpanFromContext(ctx).SetAttr("db.attempts", attempt)
+			return err
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package dbexplorer
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestRetryBackoff(t *testing.T) {
+
+	cfg := RetryConfig{BaseDelay: 10 * time.Millisecond, MaxDelay: 50 * time.Millisecond}
+
+	for attempt, want := range map[int]time.Duration{1: 10, 2: 20, 3: 40, 4: 50, 40: 50} {
+		if got := cfg.backoff(attempt, nil); got != want*time.Millisecond {
+			t.Errorf("attempt %d: got %v, want %v", attempt, got, want*time.Millisecond)
+		}
+	}
+
+	cfg.Jitter = 0.5
+
+	if got := cfg.backoff(1, func() float64 { return 0 }); got != 5*time.Millisecond {
+		t.Errorf("jitter: got %v, want 5ms", got)
+	}
+}
+
+func TestRetry(t *testing.T) {
+
+	h := &Handler{
+		Config:  Config{Retry: RetryConfig{Attempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}},
+		Metrics: NewMetrics(nil),
+	}
+
+	deadlock := &mysql.MySQLError{Number: 1213, Message: "Deadlock found"}
+
+	calls := 0
+	err := h.retry(context.Background(), "items", "update", func() error {
+		calls++
+		if calls < 3 {
+			return deadlock
+		}
+		return nil
+	})
+
+	if err != nil || calls != 3 {
+		t.Errorf("deadlocks: got %v after %d calls", err, calls)
+	}
+
+	calls = 0
+	err = h.retry(context.Background(), "items", "update", func() error {
+		calls++
+		return errors.New("syntax error")
+	})
+
+	if err == nil || calls != 1 {
+		t.Errorf("permanent error: got %v after %d calls", err, calls)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	calls = 0
+	err = h.retry(ctx, "items", "update", func() error {
+		calls++
+		return deadlock
+	})
+
+	if err != deadlock || calls != 1 {
+		t.Errorf("canceled: got %v after %d calls", err, calls)
+	}
+
+	var out strings.Builder
+	h.Metrics.WriteTo(&out)
+
+	if !strings.Contains(out.String(), `db_explorer_sql_retries_total{table="items",operation="update"} 3`) {
+		t.Errorf("retries metric missing:\n%s", out.String())
+	}
+}
@@ -0,0 +1,94 @@
+package dbexplorer
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+)
+
+// Читаем все строки результата, схема которого заранее не известна: результаты
+// процедур и произвольных запросов. Типы значений берем из rows.ColumnTypes
+func scanAll(rows *sql.Rows) ([]map[string]interface{}, error) {
+
+	records := make([]map[string]interface{}, 0)
+
+	err := scanEach(rows, func(record map[string]interface{}) error {
+		records = append(records, record)
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// Читаем строки по одной и отдаем каждую в fn. Ошибка fn прерывает чтение
+func scanEach(rows *sql.Rows, fn func(record map[string]interface{}) error) error {
+
+	columns, err := rows.ColumnTypes()
+
+	if err != nil {
+		return err
+	}
+
+	values := make([]interface{}, len(columns))
+	dest := make([]interface{}, len(columns))
+
+	for i := range values {
+		dest[i] = &values[i]
+	}
+
+	for rows.Next() {
+
+		err = rows.Scan(dest...)
+
+		if err != nil {
+			return err
+		}
+
+		record := make(map[string]interface{}, len(columns))
+
+		for i, column := range columns {
+			record[column.Name()] = convertValue(column.DatabaseTypeName(), values[i])
+		}
+
+		err = fn(record)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// Значение из драйвера в значение для JSON. В текстовом протоколе MySQL все приходит
+// байтами, поэтому числа разбираем по типу столбца
+func convertValue(databaseType string, value interface{}) interface{} {
+
+	b, ok := value.([]byte)
+
+	if !ok {
+		return value
+	}
+
+	s := string(b)
+
+	switch strings.TrimPrefix(databaseType, "UNSIGNED ") {
+
+	case "TINYINT", "SMALLINT", "MEDIUMINT", "INT", "BIGINT", "YEAR":
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return n
+		}
+
+	case "FLOAT", "DOUBLE":
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f
+		}
+	}
+
+	// DECIMAL тоже строкой: float64 потеряет точность
+	return s
+}
@@ -0,0 +1,489 @@
+package dbexplorer
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const (
+	RoutineProcedure = "PROCEDURE"
+	RoutineFunction  = "FUNCTION"
+)
+
+// Какие хранимые процедуры и функции доступны через /_rpc. Шаблоны как у таблиц.
+// Процедуры могут менять данные, поэтому без Include через API не доступна ни одна
+type RPCConfig struct {
+	Include []string `yaml:"include"`
+	Exclude []string `yaml:"exclude"`
+	// Роли, которым можно вызывать процедуры. Пусто - любой клиент, как и остальные изменения
+	Roles []string `yaml:"roles"`
+}
+
+// Параметр процедуры или функции
+type ParamInfo struct {
+	Name string
+	// IN, OUT или INOUT. У параметров функций всегда IN
+	Mode string
+	// Тип без размеров: int, varchar, decimal
+	DataType string
+	// Полный тип: varchar(255), decimal(10,2)
+	ColumnType string
+}
+
+// Хранимая процедура или функция
+type RoutineInfo struct {
+	Name string
+	// PROCEDURE или FUNCTION
+	Type   string
+	Params []ParamInfo
+	// Тип результата функции
+	Returns string
+}
+
+// Хранимые процедуры и функции текущей БД с параметрами
+func GetRoutinesInfo(ctx context.Context, db *sql.DB) ([]RoutineInfo, error) {
+
+	rows, err := db.QueryContext(ctx, `SELECT ROUTINE_NAME, ROUTINE_TYPE, DTD_IDENTIFIER
+FROM information_schema.ROUTINES WHERE ROUTINE_SCHEMA = DATABASE() ORDER BY ROUTINE_NAME`)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	routines := make([]RoutineInfo, 0)
+	// процедура и функция могут называться одинаково, ищем по типу и имени
+	index := make(map[string]int)
+
+	for rows.Next() {
+
+		var routine RoutineInfo
+		var returns sql.NullString
+
+		err = rows.Scan(&routine.Name, &routine.Type, &returns)
+
+		if err != nil {
+			return nil, err
+		}
+
+		routine.Returns = returns.String
+		index[routine.Type+" "+routine.Name] = len(routines)
+		routines = append(routines, routine)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// нулевая позиция у функции - тип результата, он уже есть в ROUTINES
+	params, err := db.QueryContext(ctx, `SELECT SPECIFIC_NAME, ROUTINE_TYPE, PARAMETER_MODE, PARAMETER_NAME, DATA_TYPE, DTD_IDENTIFIER
+FROM information_schema.PARAMETERS WHERE SPECIFIC_SCHEMA = DATABASE() AND ORDINAL_POSITION > 0
+ORDER BY SPECIFIC_NAME, ROUTINE_TYPE, ORDINAL_POSITION`)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer params.Close()
+
+	for params.Next() {
+
+		var routine, routineType string
+		var mode sql.NullString
+		var param ParamInfo
+
+		err = params.Scan(&routine, &routineType, &mode, &param.Name, &param.DataType, &param.ColumnType)
+
+		if err != nil {
+			return nil, err
+		}
+
+		param.Mode = mode.String
+
+		if param.Mode == "" {
+			param.Mode = "IN"
+		}
+
+		if idx, ok := index[routineType+" "+routine]; ok {
+			routines[idx].Params = append(routines[idx].Params, param)
+		}
+	}
+
+	return routines, params.Err()
+}
+
+// Оставляем разрешенные процедуры и функции. Пустой Include - ни одной.
+// /_rpc/{name} не различает процедуру и функцию, поэтому одинаковые имена не пропускаем
+func (c RPCConfig) apply(routines []RoutineInfo) ([]RoutineInfo, error) {
+
+	include, err := compilePatterns(c.Include)
+
+	if err != nil {
+		return nil, err
+	}
+
+	exclude, err := compilePatterns(c.Exclude)
+
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]RoutineInfo, 0, len(routines))
+	names := make(map[string]bool, len(routines))
+
+	for _, routine := range routines {
+
+		if !matchAny(include, routine.Name) || matchAny(exclude, routine.Name) {
+			continue
+		}
+
+		if names[routine.Name] {
+			return nil, fmt.Errorf("procedure and function %s have the same name, exclude it", routine.Name)
+		}
+
+		names[routine.Name] = true
+		result = append(result, routine)
+	}
+
+	return result, nil
+}
+
+// Проверяем аргумент по типу параметра и приводим его к значению для драйвера
+func checkArg(param ParamInfo, value interface{}) (interface{}, error) {
+
+	if value == nil {
+		return nil, nil
+	}
+
+	problem := FieldProblem(http.StatusBadRequest, CodeInvalidType, param.Name,
+		fmt.Sprintf("argument %s must be %s", param.Name, param.ColumnType))
+
+	switch param.DataType {
+
+	case "tinyint", "smallint", "mediumint", "int", "bigint", "year":
+
+		if b, ok := value.(bool); ok {
+			if b {
+				return int64(1), nil
+			}
+			return int64(0), nil
+		}
+
+		n, ok := value.(json.Number)
+
+		if !ok {
+			return nil, problem
+		}
+
+		i, err := n.Int64()
+
+		if err != nil {
+			return nil, problem
+		}
+
+		return i, nil
+
+	case "decimal", "float", "double", "real":
+
+		n, ok := value.(json.Number)
+
+		if !ok {
+			return nil, problem
+		}
+
+		// DECIMAL передаем строкой, чтобы не терять точность
+		return n.String(), nil
+	}
+
+	s, ok := value.(string)
+
+	if !ok {
+		return nil, problem
+	}
+
+	return s, nil
+}
+
+// Хендлер для вызова хранимой процедуры или функции. Аргументы - JSON-объект в теле.
+// Вызывается по эндпоинту "/_rpc/{name}" [POST]
+func (h *Handler) RPC(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed,
+			fmt.Sprintf("method %s is not allowed", r.Method))
+		return
+	}
+
+	if len(h.Config.RPC.Roles) > 0 && !h.authorize(w, r, h.Config.RPC.Roles) {
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, "/_rpc/")
+
+	routine, ok := h.Schema().routine(name)
+
+	if !ok {
+		writeError(w, r, http.StatusNotFound, CodeUnknownRoutine, fmt.Sprintf("unknown routine %s", name))
+		return
+	}
+
+	body := make(map[string]interface{})
+
+	decoder := json.NewDecoder(r.Body)
+	decoder.UseNumber()
+
+	err := decoder.Decode(&body)
+
+	if err != nil && err != io.EOF {
+		writeError(w, r, http.StatusBadRequest, CodeInvalidBody, "request body must be a JSON object")
+		return
+	}
+
+	args := make([]interface{}, len(routine.Params))
+
+	for key := range body {
+		if _, ok := routine.param(key); !ok {
+			writeProblem(w, r, FieldProblem(http.StatusBadRequest, CodeUnknownField, key,
+				fmt.Sprintf("%s has no parameter %s", routine.Name, key)))
+			return
+		}
+	}
+
+	for i, param := range routine.Params {
+
+		if param.Mode == "OUT" {
+			continue
+		}
+
+		args[i], err = checkArg(param, body[param.Name])
+
+		if err != nil {
+			writeProblem(w, r, err.(*Problem))
+			return
+		}
+	}
+
+	ctx, cancel := h.operationContext(r.Context(), "rpc")
+	defer cancel()
+
+	var response map[string]interface{}
+
+	if routine.Type == RoutineFunction {
+		response, err = h.callFunction(ctx, routine, args)
+	} else {
+		response, err = h.callProcedure(ctx, routine, args)
+	}
+
+	if err != nil {
+		h.dbError(ctx, w, r, err, "bad routine call", "routine", routine.Name)
+		return
+	}
+
+	err = h.writeAudit(r, AuditEntry{
+		Table:     routine.Name,
+		Operation: AuditCall,
+		After:     map[string]interface{}{"args": body},
+	})
+
+	if err != nil {
+		writeInternalError(w, r)
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"response": response,
+	})
+}
+
+func (s *Schema) routine(name string) (RoutineInfo, bool) {
+
+	for _, routine := range s.Routines {
+		if routine.Name == name {
+			return routine, true
+		}
+	}
+
+	return RoutineInfo{}, false
+}
+
+func (r RoutineInfo) param(name string) (ParamInfo, bool) {
+
+	for _, param := range r.Params {
+		if param.Name == name {
+			return param, true
+		}
+	}
+
+	return ParamInfo{}, false
+}
+
+func quoteIdent(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+// SELECT f(?, ?) - результат функции
+func (h *Handler) callFunction(ctx context.Context, routine RoutineInfo, args []interface{}) (map[string]interface{}, error) {
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(args)), ",")
+
+	rows, err := h.queryOn(ctx, h.DB, routine.Name, "rpc",
+		fmt.Sprintf("SELECT %s(%s) AS result", quoteIdent(routine.Name), placeholders), args...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	records, err := scanAll(rows)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var result interface{}
+
+	if len(records) > 0 {
+		result = records[0]["result"]
+	}
+
+	return map[string]interface{}{"result": result}, nil
+}
+
+// CALL p(?, @out) на одном соединении: OUT-параметры живут в переменных сессии.
+// Процедуру не повторяем при ошибках - она может успеть что-то изменить
+func (h *Handler) callProcedure(ctx context.Context, routine RoutineInfo, args []interface{}) (map[string]interface{}, error) {
+
+	conn, err := h.DB.Conn(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer conn.Close()
+
+	placeholders := make([]string, len(routine.Params))
+	callArgs := make([]interface{}, 0, len(args))
+	outs := make([]string, 0)
+
+	for i, param := range routine.Params {
+
+		if param.Mode == "IN" {
+			placeholders[i] = "?"
+			callArgs = append(callArgs, args[i])
+			continue
+		}
+
+		variable := fmt.Sprintf("@_rpc_%d", i)
+		placeholders[i] = variable
+		outs = append(outs, fmt.Sprintf("%s AS %s", variable, quoteIdent(param.Name)))
+
+		// переменная живет до конца сессии, а соединение вернется в пул
+		_, err = h.execOn(ctx, conn, routine.Name, "rpc", fmt.Sprintf("SET %s = ?", variable), args[i])
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	rows, err := h.queryOn(ctx, conn, routine.Name, "rpc",
+		fmt.Sprintf("CALL %s(%s)", quoteIdent(routine.Name), strings.Join(placeholders, ",")), callArgs...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	resultSets := make([][]map[string]interface{}, 0)
+	returned := 0
+	truncated := false
+
+	for {
+
+		columns, err := rows.Columns()
+
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		records := make([]map[string]interface{}, 0)
+
+		// держим в памяти не больше sql.max_rows строк на все результаты. Остальные
+		// драйвер пропускает в NextResultSet: запрос не отменяем, соединение еще нужно для OUT
+		err = scanEach(rows, func(record map[string]interface{}) error {
+
+			if returned == h.Config.SQL.MaxRows {
+				truncated = true
+				return errRowLimit
+			}
+
+			returned++
+			records = append(records, record)
+
+			return nil
+		})
+
+		if err != nil && err != errRowLimit {
+			rows.Close()
+			return nil, err
+		}
+
+		// последний результат CALL - статус без столбцов
+		if len(columns) > 0 {
+			resultSets = append(resultSets, records)
+			h.rowsReturned(ctx, routine.Name, "rpc", len(records))
+		}
+
+		if !rows.NextResultSet() {
+			break
+		}
+	}
+
+	// ошибка следующего запроса процедуры приходит сюда, а не в NextResultSet
+	if err = rows.Err(); err != nil {
+		rows.Close()
+		return nil, err
+	}
+
+	err = rows.Close()
+
+	if err != nil {
+		return nil, err
+	}
+
+	response := map[string]interface{}{"result_sets": resultSets, "truncated": truncated}
+
+	if len(outs) == 0 {
+		return response, nil
+	}
+
+	outRows, err := h.queryOn(ctx, conn, routine.Name, "rpc", "SELECT "+strings.Join(outs, ", "))
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer outRows.Close()
+
+	records, err := scanAll(outRows)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(records) == 0 {
+		return nil, errors.New("no values of OUT parameters")
+	}
+
+	response["out"] = records[0]
+
+	return response, nil
+}
@@ -0,0 +1,129 @@
+package dbexplorer
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCheckArg(t *testing.T) {
+
+	cases := []struct {
+		param ParamInfo
+		value interface{}
+		want  interface{}
+		fail  bool
+	}{
+		{ParamInfo{Name: "id", DataType: "int", ColumnType: "int"}, json.Number("42"), int64(42), false},
+		{ParamInfo{Name: "id", DataType: "int", ColumnType: "int"}, json.Number("4.2"), nil, true},
+		{ParamInfo{Name: "id", DataType: "int", ColumnType: "int"}, "42", nil, true},
+		{ParamInfo{Name: "flag", DataType: "tinyint", ColumnType: "tinyint(1)"}, true, int64(1), false},
+		{ParamInfo{Name: "sum", DataType: "decimal", ColumnType: "decimal(10,2)"}, json.Number("10.25"), "10.25", false},
+		{ParamInfo{Name: "name", DataType: "varchar", ColumnType: "varchar(255)"}, "bob", "bob", false},
+		{ParamInfo{Name: "name", DataType: "varchar", ColumnType: "varchar(255)"}, json.Number("1"), nil, true},
+		{ParamInfo{Name: "name", DataType: "varchar", ColumnType: "varchar(255)"}, nil, nil, false},
+	}
+
+	for _, c := range cases {
+
+		got, err := checkArg(c.param, c.value)
+
+		if c.fail {
+			if p, ok := err.(*Problem); !ok || p.Field != c.param.Name {
+				t.Errorf("%s=%v: got %v, want field problem", c.param.Name, c.value, err)
+			}
+			continue
+		}
+
+		if err != nil || got != c.want {
+			t.Errorf("%s=%v: got %#v, %v, want %#v", c.param.Name, c.value, got, err, c.want)
+		}
+	}
+}
+
+func TestConvertValue(t *testing.T) {
+
+	cases := []struct {
+		databaseType string
+		value        interface{}
+		want         interface{}
+	}{
+		{"BIGINT", []byte("7"), int64(7)},
+		{"UNSIGNED INT", []byte("7"), int64(7)},
+		{"DOUBLE", []byte("1.5"), 1.5},
+		{"DECIMAL", []byte("1.50"), "1.50"},
+		{"VARCHAR", []byte("bob"), "bob"},
+		{"INT", int64(3), int64(3)},
+		{"VARCHAR", nil, nil},
+	}
+
+	for _, c := range cases {
+		if got := convertValue(c.databaseType, c.value); got != c.want {
+			t.Errorf("%s %v: got %#v, want %#v", c.databaseType, c.value, got, c.want)
+		}
+	}
+}
+
+func TestRPCConfigApply(t *testing.T) {
+
+	routines := []RoutineInfo{{Name: "calc_total"}, {Name: "calc_tax"}, {Name: "_internal_cleanup"}}
+
+	got, err := RPCConfig{Include: []string{"calc_*"}, Exclude: []string{"calc_tax"}}.apply(routines)
+
+	if err != nil || len(got) != 1 || got[0].Name != "calc_total" {
+		t.Errorf("got %+v, %v", got, err)
+	}
+
+	// без include не открываем ни одной процедуры
+	got, err = RPCConfig{}.apply(routines)
+
+	if err != nil || len(got) != 0 {
+		t.Errorf("empty include: got %+v, %v", got, err)
+	}
+
+	routines = append(routines, RoutineInfo{Name: "calc_total", Type: RoutineFunction})
+
+	if _, err = (RPCConfig{Include: []string{"calc_*"}}).apply(routines); err == nil {
+		t.Error("procedure and function with the same name accepted")
+	}
+
+	got, err = RPCConfig{Include: []string{"calc_*"}, Exclude: []string{"calc_total"}}.apply(routines)
+
+	if err != nil || len(got) != 1 || got[0].Name != "calc_tax" {
+		t.Errorf("excluded duplicate: got %+v, %v", got, err)
+	}
+}
+
+func TestRPCRoles(t *testing.T) {
+
+	cases := []struct {
+		roles  []string
+		key    string
+		status int
+	}{
+		{nil, "", http.StatusNotFound},
+		{[]string{"admin"}, "", http.StatusUnauthorized},
+		{[]string{"admin"}, "k2", http.StatusForbidden},
+		{[]string{"admin"}, "k1", http.StatusNotFound},
+	}
+
+	for _, c := range cases {
+
+		h := &Handler{Config: Config{
+			RPC:  RPCConfig{Roles: c.roles},
+			Auth: AuthConfig{Keys: map[string][]string{"k1": {"admin"}, "k2": {"reader"}}},
+		}.withDefaults()}
+
+		r := httptest.NewRequest(http.MethodPost, "/_rpc/calc_total", strings.NewReader("{}"))
+		r.Header.Set("X-API-Key", c.key)
+
+		w := httptest.NewRecorder()
+		h.RPC(w, r)
+
+		if w.Code != c.status {
+			t.Errorf("roles %v, key %q: got status %d, want %d", c.roles, c.key, w.Code, c.status)
+		}
+	}
+}
@@ -0,0 +1,288 @@
+package dbexplorer
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Настройки перечитывания схемы БД. Нулевые интервалы выключают фоновую проверку
+type SchemaConfig struct {
+	// Как часто перечитывать схему целиком
+	ReloadInterval time.Duration `yaml:"reload_interval"`
+	// Как часто сверять контрольную сумму information_schema и перечитывать схему,
+	// если она изменилась
+	ChecksumInterval time.Duration `yaml:"checksum_interval"`
+	// Роли, которым можно перечитать схему через /_admin/reload
+	Roles []string `yaml:"roles"`
+}
+
+// Снимок схемы БД. После публикации не меняется: перечитывание создает новый снимок
+// и атомарно подменяет старый, запросы в работе дорабатывают со своим снимком
+type Schema struct {
+	Tables []TableInfo
+	// Процедуры и функции для /_rpc
+	Routines []RoutineInfo
+	Checksum string
+	LoadedAt time.Time
+}
+
+// Текущий снимок схемы. Хендлер берет его один раз в начале запроса
+func (h *Handler) Schema() *Schema {
+
+	if schema := h.schema.Load(); schema != nil {
+		return schema
+	}
+
+	return &Schema{}
+}
+
+// Таблицы из текущего снимка схемы
+func (h *Handler) tables() []TableInfo {
+	return h.Schema().Tables
+}
+
+// Контрольная сумма описания столбцов всех таблиц и хранимых процедур текущей БД
+func SchemaChecksum(ctx context.Context, db *sql.DB) (string, error) {
+
+	hash := sha256.New()
+
+	queries := []string{
+		`SELECT TABLE_NAME, COLUMN_NAME, COLUMN_TYPE, IS_NULLABLE, COLUMN_KEY
+FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = DATABASE()
+ORDER BY TABLE_NAME, ORDINAL_POSITION`,
+		// процедуру нельзя изменить, не пересоздав ее, а при этом меняется CREATED
+		`SELECT ROUTINE_NAME, ROUTINE_TYPE, CREATED, LAST_ALTERED
+FROM information_schema.ROUTINES WHERE ROUTINE_SCHEMA = DATABASE() ORDER BY ROUTINE_NAME`,
+		// от FULLTEXT-индексов зависит поиск по ?q=
+		`SELECT TABLE_NAME, INDEX_NAME, COLUMN_NAME FROM information_schema.STATISTICS
+WHERE TABLE_SCHEMA = DATABASE() AND INDEX_TYPE = 'FULLTEXT'
+ORDER BY TABLE_NAME, INDEX_NAME, SEQ_IN_INDEX`,
+	}
+
+	for _, query := range queries {
+
+		err := hashRows(ctx, db, hash, query)
+
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Дописываем в hash все строки результата запроса
+func hashRows(ctx context.Context, db *sql.DB, hash io.Writer, query string) error {
+
+	rows, err := db.QueryContext(ctx, query)
+
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+
+	if err != nil {
+		return err
+	}
+
+	values := make([]sql.RawBytes, len(columns))
+	dest := make([]interface{}, len(columns))
+
+	for i := range values {
+		dest[i] = &values[i]
+	}
+
+	for rows.Next() {
+
+		err = rows.Scan(dest...)
+
+		if err != nil {
+			return err
+		}
+
+		for _, value := range values {
+			hash.Write(value)
+			hash.Write([]byte{0})
+		}
+
+		hash.Write([]byte{'\n'})
+	}
+
+	return rows.Err()
+}
+
+// Перечитываем схему. Без force сначала сверяем контрольную сумму и ничего не делаем,
+// если схема не менялась. Возвращаем актуальный снимок и признак замены
+func (h *Handler) ReloadSchema(ctx context.Context, force bool) (*Schema, bool, error) {
+
+	// перечитывания не должны обгонять друг друга и публиковать старый снимок поверх нового
+	h.reloadMu.Lock()
+	defer h.reloadMu.Unlock()
+
+	current := h.Schema()
+
+	checksum, err := SchemaChecksum(ctx, h.DB)
+
+	if err != nil {
+		return current, false, err
+	}
+
+	if !force && checksum == current.Checksum {
+		return current, false, nil
+	}
+
+	tables, err := GetTablesInfo(ctx, h.DB)
+
+	if err != nil {
+		return current, false, err
+	}
+
+	fullText, err := h.fullTextIndexes(ctx)
+
+	if err != nil {
+		return current, false, err
+	}
+
+	for i := range tables {
+		tables[i].FullText = fullText[tables[i].Name]
+	}
+
+	// таблица журнала аудита - служебная и через API не доступна
+	if h.Config.Audit.Sink == AuditSinkDB {
+		if ok, idx, _ := contains(tables, h.Config.Audit.Table); ok {
+			tables = append(tables[:idx], tables[idx+1:]...)
+		}
+	}
+
+	tables, err = h.Config.Tables.apply(tables)
+
+	if err != nil {
+		return current, false, err
+	}
+
+	routines, err := GetRoutinesInfo(ctx, h.DB)
+
+	if err != nil {
+		return current, false, err
+	}
+
+	routines, err = h.Config.RPC.apply(routines)
+
+	if err != nil {
+		return current, false, err
+	}
+
+	schema := &Schema{Tables: tables, Routines: routines, Checksum: checksum, LoadedAt: time.Now().UTC()}
+
+	h.schema.Store(schema)
+
+	changed := checksum != current.Checksum
+
+	names := make([]string, 0, len(tables))
+
+	for _, table := range tables {
+		names = append(names, table.Name)
+	}
+
+	loggerFrom(ctx).Info("schema loaded", "tables", names, "routines", len(routines),
+		"checksum", checksum, "changed", changed)
+
+	return schema, changed, nil
+}
+
+// Фоновое перечитывание схемы по таймерам из настроек. Работает до отмены ctx
+func (h *Handler) watchSchema(ctx context.Context) {
+
+	cfg := h.Config.Schema
+
+	reload, stopReload := newTicker(cfg.ReloadInterval)
+	defer stopReload()
+
+	checksum, stopChecksum := newTicker(cfg.ChecksumInterval)
+	defer stopChecksum()
+
+	for {
+
+		force := false
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-reload:
+			force = true
+		case <-checksum:
+		}
+
+		_, _, err := h.ReloadSchema(ctx, force)
+
+		if err != nil && ctx.Err() == nil {
+			h.Logger.Warn("bad schema reload", "error", err)
+		}
+	}
+}
+
+// Канал тикера и его остановка. Для нулевого интервала канал nil, из него ничего не придет
+func newTicker(interval time.Duration) (<-chan time.Time, func()) {
+
+	if interval <= 0 {
+		return nil, func() {}
+	}
+
+	ticker := time.NewTicker(interval)
+
+	return ticker.C, ticker.Stop
+}
+
+// Хендлер для перечитывания схемы БД. Полное чтение information_schema дорогое,
+// поэтому только для ролей из schema.roles. Вызывается по эндпоинту "/_admin/reload" [POST]
+func (h *Handler) AdminReload(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed,
+			fmt.Sprintf("method %s is not allowed", r.Method))
+		return
+	}
+
+	if !h.authorize(w, r, h.Config.Schema.Roles) {
+		return
+	}
+
+	schema, changed, err := h.ReloadSchema(r.Context(), true)
+
+	if err != nil {
+		h.dbError(r.Context(), w, r, err, "bad schema reload")
+		return
+	}
+
+	names := make([]string, 0, len(schema.Tables))
+
+	for _, table := range schema.Tables {
+		names = append(names, table.Name)
+	}
+
+	routines := make([]string, 0, len(schema.Routines))
+
+	for _, routine := range schema.Routines {
+		routines = append(routines, routine.Name)
+	}
+
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"response": map[string]interface{}{
+			"tables":    names,
+			"routines":  routines,
+			"checksum":  schema.Checksum,
+			"changed":   changed,
+			"loaded_at": schema.LoadedAt,
+		},
+	})
+}
@@ -0,0 +1,77 @@
+package dbexplorer
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// Запускать с -race: запросы читают схему, пока ее подменяют
+func TestSchemaSwap(t *testing.T) {
+
+	h := &Handler{}
+
+	if len(h.tables()) != 0 {
+		t.Fatal("schema before first load is not empty")
+	}
+
+	h.schema.Store(&Schema{Tables: []TableInfo{{Name: "items", ID: "id"}}})
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 4; i++ {
+
+		wg.Add(1)
+
+		go func() {
+
+			defer wg.Done()
+
+			for j := 0; j < 1000; j++ {
+
+				table, _ := h.requestLabels(httptest.NewRequest(http.MethodGet, "/items", nil))
+
+				if table != "items" {
+					t.Errorf("got table %q in the middle of a swap", table)
+					return
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 1000; i++ {
+		h.schema.Store(&Schema{Tables: []TableInfo{{Name: "users", ID: "user_id"}, {Name: "items", ID: "id"}}})
+	}
+
+	wg.Wait()
+}
+
+func TestAdminReloadNeedsRole(t *testing.T) {
+
+	h := &Handler{Config: Config{Auth: AuthConfig{
+		Keys: map[string][]string{"k1": {"reader"}},
+	}}.withDefaults()}
+
+	for key, status := range map[string]int{"": http.StatusUnauthorized, "k1": http.StatusForbidden} {
+
+		r := httptest.NewRequest(http.MethodPost, "/_admin/reload", nil)
+		r.Header.Set("X-API-Key", key)
+
+		w := httptest.NewRecorder()
+		h.AdminReload(w, r)
+
+		if w.Code != status {
+			t.Errorf("key %q: got status %d, want %d", key, w.Code, status)
+		}
+	}
+}
+
+// Упавший SHOW TABLES должен вернуть ошибку, а не уронить процесс на nil *sql.Rows
+func TestGetTablesInfoError(t *testing.T) {
+
+	if _, err := GetTablesInfo(context.Background(), unreachableDB(t)); err == nil {
+		t.Fatal("expected an error from an unreachable database")
+	}
+}
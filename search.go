@@ -0,0 +1,283 @@
+package dbexplorer
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+// Сколько слов из ?q= учитываем при поиске через LIKE
+const maxSearchTokens = 10
+
+// Сколько символов вокруг найденного слова показываем во фрагменте
+const snippetContext = 40
+
+// Столбцы FULLTEXT-индексов по таблицам. Если индексов у таблицы несколько,
+// берем самый широкий: MATCH работает только по столбцам одного индекса целиком
+func (h *Handler) fullTextIndexes(ctx context.Context) (map[string][]string, error) {
+
+	rows, err := h.queryOn(ctx, h.DB, "", "schema", `SELECT TABLE_NAME, INDEX_NAME, COLUMN_NAME
+FROM information_schema.STATISTICS WHERE TABLE_SCHEMA = DATABASE() AND INDEX_TYPE = 'FULLTEXT'
+ORDER BY TABLE_NAME, INDEX_NAME, SEQ_IN_INDEX`)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	columns := make([]indexColumn, 0)
+
+	for rows.Next() {
+
+		var column indexColumn
+
+		err = rows.Scan(&column.Table, &column.Index, &column.Column)
+
+		if err != nil {
+			return nil, err
+		}
+
+		columns = append(columns, column)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return widestIndexes(columns), nil
+}
+
+// Столбец FULLTEXT-индекса из information_schema.STATISTICS
+type indexColumn struct {
+	Table  string
+	Index  string
+	Column string
+}
+
+// Для каждой таблицы берем индекс с наибольшим числом столбцов. Столбцы идут
+// по таблицам и именам индексов, поэтому при равной ширине остается первый по имени
+func widestIndexes(columns []indexColumn) map[string][]string {
+
+	result := make(map[string][]string)
+
+	var table, index string
+	var current []string
+
+	keep := func() {
+		if len(current) > len(result[table]) {
+			result[table] = current
+		}
+	}
+
+	for _, column := range columns {
+
+		if column.Table != table || column.Index != index {
+			keep()
+			table, index, current = column.Table, column.Index, nil
+		}
+
+		current = append(current, column.Column)
+	}
+
+	keep()
+
+	return result
+}
+
+// Поиск по ?q=: условие для WHERE и выражение релевантности для ORDER BY
+type search struct {
+	Where     string
+	WhereArgs []interface{}
+	Score     string
+	ScoreArgs []interface{}
+	// Слова запроса для подсветки во фрагментах
+	Tokens []string
+}
+
+// Текстовый ли тип столбца: varchar(255), char(2), text, longtext
+func isTextType(columnType string) bool {
+
+	switch baseType(columnType) {
+	case "char", "varchar", "tinytext", "text", "mediumtext", "longtext":
+		return true
+	}
+
+	return false
+}
+
+// Экранируем % и _ для LIKE
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// Собираем поиск по таблице. С FULLTEXT-индексом - MATCH ... AGAINST,
+// без него - каждое слово должно найтись LIKE хотя бы в одном текстовом столбце
+func buildSearch(table TableInfo, q string) (*search, error) {
+
+	tokens := strings.Fields(q)
+
+	if len(tokens) == 0 {
+		return nil, FieldProblem(http.StatusBadRequest, CodeInvalidParam, "q", "search query is empty")
+	}
+
+	if len(tokens) > maxSearchTokens {
+		tokens = tokens[:maxSearchTokens]
+	}
+
+	if len(table.FullText) > 0 {
+
+		match := fmt.Sprintf("MATCH(%s) AGAINST (? IN NATURAL LANGUAGE MODE)", strings.Join(table.FullText, ","))
+
+		return &search{
+			Where:     match,
+			WhereArgs: []interface{}{q},
+			Score:     match,
+			ScoreArgs: []interface{}{q},
+			Tokens:    tokens,
+		}, nil
+	}
+
+	columns := make([]string, 0)
+
+	for _, field := range table.Fields {
+		if isTextType(field.ColumnType) {
+			columns = append(columns, field.Column())
+		}
+	}
+
+	if len(columns) == 0 {
+		return nil, FieldProblem(http.StatusBadRequest, CodeInvalidParam, "q",
+			fmt.Sprintf("table %s has no text fields to search", table.Name))
+	}
+
+	s := &search{Tokens: tokens}
+
+	where := make([]string, 0, len(tokens))
+	score := make([]string, 0, len(tokens)*len(columns))
+
+	for _, token := range tokens {
+
+		pattern := "%" + escapeLike(token) + "%"
+		alternatives := make([]string, 0, len(columns))
+
+		for _, column := range columns {
+			alternatives = append(alternatives, column+" LIKE ?")
+			s.WhereArgs = append(s.WhereArgs, pattern)
+			// релевантность - сколько раз слово нашлось в разных столбцах
+			score = append(score, "("+column+" LIKE ?)")
+			s.ScoreArgs = append(s.ScoreArgs, pattern)
+		}
+
+		where = append(where, "("+strings.Join(alternatives, " OR ")+")")
+	}
+
+	s.Where = strings.Join(where, " AND ")
+	s.Score = strings.Join(score, " + ")
+
+	return s, nil
+}
+
+// Фрагменты текстовых полей записи с найденными словами в <mark>. Текст экранирован для HTML
+func snippets(table TableInfo, record map[string]interface{}, tokens []string) map[string]string {
+
+	result := make(map[string]string)
+
+	for _, field := range table.Fields {
+
+		text, ok := record[field.Name].(string)
+
+		if !ok || !isTextType(field.ColumnType) {
+			continue
+		}
+
+		if snippet, ok := highlight(text, tokens); ok {
+			result[field.Name] = snippet
+		}
+	}
+
+	return result
+}
+
+// Фрагмент вокруг первого найденного слова, все слова в нем подсвечены
+func highlight(text string, tokens []string) (string, bool) {
+
+	lower := strings.ToLower(text)
+
+	lowerTokens := make([]string, len(tokens))
+
+	for i, token := range tokens {
+		lowerTokens[i] = strings.ToLower(token)
+	}
+
+	first := -1
+
+	for _, token := range lowerTokens {
+		if i := strings.Index(lower, token); i >= 0 && (first < 0 || i < first) {
+			first = i
+		}
+	}
+
+	// у ToLower может поменяться длина в байтах, тогда позиции не совпадут
+	if first < 0 || len(lower) != len(text) {
+		return "", false
+	}
+
+	start, end := first, first
+
+	for n := 0; start > 0 && n < snippetContext; n++ {
+		_, size := utf8.DecodeLastRuneInString(text[:start])
+		start -= size
+	}
+
+	for n := 0; end < len(text) && n < snippetContext*2; n++ {
+		_, size := utf8.DecodeRuneInString(text[end:])
+		end += size
+	}
+
+	var b strings.Builder
+
+	if start > 0 {
+		b.WriteString("…")
+	}
+
+	fragment, fragmentLower := text[start:end], lower[start:end]
+
+	for i := 0; i < len(fragment); {
+
+		matched := 0
+
+		for _, token := range lowerTokens {
+			if strings.HasPrefix(fragmentLower[i:], token) && len(token) > matched {
+				matched = len(token)
+			}
+		}
+
+		if matched > 0 {
+			b.WriteString("<mark>" + html.EscapeString(fragment[i:i+matched]) + "</mark>")
+			i += matched
+			continue
+		}
+
+		_, size := utf8.DecodeRuneInString(fragment[i:])
+		b.WriteString(html.EscapeString(fragment[i : i+size]))
+		i += size
+	}
+
+	if end < len(text) {
+		b.WriteString("…")
+	}
+
+	return b.String(), true
+}
+
+// Нужны ли фрагменты: ?snippets=1 или ?snippets=true
+func snippetsWanted(r *http.Request) bool {
+
+	value := r.URL.Query().Get("snippets")
+
+	return value == "1" || value == "true"
+}
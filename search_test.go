@@ -0,0 +1,114 @@
+package dbexplorer
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBuildSearch(t *testing.T) {
+
+	table := TableInfo{Name: "items", ID: "id", Fields: []FieldInfo{
+		{Name: "id", ColumnType: TypeInt, IsKey: true},
+		{Name: "title", ColumnType: TypeVarchar},
+		{Name: "description", DBName: "descr", ColumnType: TypeText},
+	}}
+
+	s, err := buildSearch(table, "go 100%")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.Where != "(title LIKE ? OR descr LIKE ?) AND (title LIKE ? OR descr LIKE ?)" {
+		t.Errorf("got where %q", s.Where)
+	}
+
+	if s.Score != "(title LIKE ?) + (descr LIKE ?) + (title LIKE ?) + (descr LIKE ?)" {
+		t.Errorf("got score %q", s.Score)
+	}
+
+	want := []interface{}{"%go%", "%go%", `%100\%%`, `%100\%%`}
+
+	if !reflect.DeepEqual(s.WhereArgs, want) || !reflect.DeepEqual(s.ScoreArgs, want) {
+		t.Errorf("got args %v %v", s.WhereArgs, s.ScoreArgs)
+	}
+
+	table.FullText = []string{"title", "descr"}
+
+	s, err = buildSearch(table, "go lang")
+
+	if err != nil || s.Where != "MATCH(title,descr) AGAINST (? IN NATURAL LANGUAGE MODE)" ||
+		!reflect.DeepEqual(s.WhereArgs, []interface{}{"go lang"}) {
+		t.Errorf("fulltext: got %+v, %v", s, err)
+	}
+
+	if _, err = buildSearch(table, "  "); err == nil {
+		t.Error("empty query must fail")
+	}
+
+	numbers := TableInfo{Name: "stats", Fields: []FieldInfo{{Name: "id", ColumnType: TypeInt}}}
+
+	if _, err = buildSearch(numbers, "go"); err == nil {
+		t.Error("table without text fields must fail")
+	}
+}
+
+func TestHighlight(t *testing.T) {
+
+	cases := []struct {
+		text   string
+		tokens []string
+		want   string
+		ok     bool
+	}{
+		{"Learn Go <fast>", []string{"go"}, "Learn <mark>Go</mark> &lt;fast&gt;", true},
+		{"nothing here", []string{"go"}, "", false},
+		{
+			strings.Repeat("я", 45) + " Go " + strings.Repeat("ж", 100),
+			[]string{"go"},
+			"…" + strings.Repeat("я", 39) + " <mark>Go</mark> " + strings.Repeat("ж", 77) + "…",
+			true,
+		},
+	}
+
+	for _, c := range cases {
+		if got, ok := highlight(c.text, c.tokens); got != c.want || ok != c.ok {
+			t.Errorf("%q: got %q %t", c.text, got, ok)
+		}
+	}
+}
+
+func TestFullTextIndexesContext(t *testing.T) {
+
+	h := &Handler{DB: unreachableDB(t)}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := h.fullTextIndexes(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+}
+
+func TestWidestIndexes(t *testing.T) {
+
+	columns := []indexColumn{
+		{"items", "ft_description", "description"},
+		{"items", "ft_title", "title"},
+		{"posts", "ft_body", "body"},
+		{"posts", "ft_full", "title"},
+		{"posts", "ft_full", "body"},
+	}
+
+	want := map[string][]string{
+		"items": {"description"},
+		"posts": {"title", "body"},
+	}
+
+	if got := widestIndexes(columns); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
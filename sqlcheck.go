@@ -0,0 +1,188 @@
+package dbexplorer
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Лексема SQL: слово, строка, число, знак
+type sqlToken struct {
+	kind  int
+	value string
+}
+
+const (
+	tokenWord = iota
+	tokenString
+	tokenQuotedIdent
+	tokenNumber
+	tokenVariable
+	tokenSymbol
+)
+
+// Разбиваем SQL на лексемы. Комментарии выбрасываем, строки и `имена` остаются
+// одной лексемой, поэтому слова внутри них не считаются ключевыми
+func tokenizeSQL(query string) ([]sqlToken, error) {
+
+	tokens := make([]sqlToken, 0)
+
+	for i := 0; i < len(query); {
+
+		c := query[i]
+
+		switch {
+
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+
+		case c == '#' || c == '-' && isLineComment(query[i:]):
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				return tokens, nil
+			}
+			i += end + 1
+
+		case c == '/' && strings.HasPrefix(query[i:], "/*"):
+			// /*! ... */ MySQL выполняет как код
+			if strings.HasPrefix(query[i:], "/*!") || strings.HasPrefix(query[i:], "/*+") {
+				return nil, errors.New("executable comments are not allowed")
+			}
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				return nil, errors.New("unterminated comment")
+			}
+			i += end + 4
+
+		case c == '\'' || c == '"' || c == '`':
+			end, err := quotedEnd(query, i)
+			if err != nil {
+				return nil, err
+			}
+			kind := tokenString
+			if c == '`' {
+				kind = tokenQuotedIdent
+			}
+			tokens = append(tokens, sqlToken{kind, query[i:end]})
+			i = end
+
+		case c == '@':
+			start := i
+			i++
+			for i < len(query) && (isWordByte(query[i]) || query[i] == '@') {
+				i++
+			}
+			tokens = append(tokens, sqlToken{tokenVariable, query[start:i]})
+
+		case isWordByte(c):
+			start := i
+			for i < len(query) && (isWordByte(query[i]) || query[i] == '.') {
+				i++
+			}
+			kind := tokenWord
+			if c >= '0' && c <= '9' {
+				kind = tokenNumber
+			}
+			tokens = append(tokens, sqlToken{kind, query[start:i]})
+
+		case c == ':' && strings.HasPrefix(query[i:], ":="):
+			tokens = append(tokens, sqlToken{tokenSymbol, ":="})
+			i += 2
+
+		default:
+			tokens = append(tokens, sqlToken{tokenSymbol, string(c)})
+			i++
+		}
+	}
+
+	return tokens, nil
+}
+
+// "--" - комментарий, только если за ним пробел, управляющий символ или конец
+func isLineComment(s string) bool {
+	return strings.HasPrefix(s, "--") && (len(s) == 2 || s[2] <= ' ')
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c == '$' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' ||
+		c >= '0' && c <= '9' || c >= 0x80
+}
+
+// Конец строки в кавычках, начиная с позиции открывающей кавычки. Кавычка внутри
+// экранируется удвоением или обратным слешем (кроме `имен`)
+func quotedEnd(query string, start int) (int, error) {
+
+	quote := query[start]
+
+	for i := start + 1; i < len(query); i++ {
+
+		switch {
+		case query[i] == '\\' && quote != '`':
+			i++
+		case query[i] == quote && i+1 < len(query) && query[i+1] == quote:
+			i++
+		case query[i] == quote:
+			return i + 1, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unterminated %c quote", quote)
+}
+
+// Слова, которых не может быть в запросе только на чтение
+var forbiddenSQLWords = map[string]bool{
+	"INSERT": true, "UPDATE": true, "DELETE": true, "REPLACE": true,
+	"CREATE": true, "ALTER": true, "DROP": true, "TRUNCATE": true, "RENAME": true,
+	"GRANT": true, "REVOKE": true, "LOCK": true, "UNLOCK": true, "CALL": true,
+	"DO": true, "HANDLER": true, "LOAD": true, "SET": true, "INTO": true,
+	"OUTFILE": true, "DUMPFILE": true, "SHARE": true, "PREPARE": true, "EXECUTE": true,
+	"LOAD_FILE": true, "GET_LOCK": true, "RELEASE_LOCK": true, "SLEEP": true,
+	"BENCHMARK": true, "SHUTDOWN": true, "KILL": true,
+}
+
+// Проверяем, что запрос - ровно один SELECT без изменений данных. Это грубый фильтр
+// на входе, вторая линия защиты - транзакция только на чтение
+func checkReadOnlySQL(query string) error {
+
+	tokens, err := tokenizeSQL(query)
+
+	if err != nil {
+		return err
+	}
+
+	// одна точка с запятой в конце допустима
+	if n := len(tokens); n > 0 && tokens[n-1].kind == tokenSymbol && tokens[n-1].value == ";" {
+		tokens = tokens[:n-1]
+	}
+
+	if len(tokens) == 0 {
+		return errors.New("query is empty")
+	}
+
+	first := tokens[0]
+
+	for first.kind == tokenSymbol && first.value == "(" && len(tokens) > 1 {
+		tokens = tokens[1:]
+		first = tokens[0]
+	}
+
+	if first.kind != tokenWord || !strings.EqualFold(first.value, "SELECT") && !strings.EqualFold(first.value, "WITH") {
+		return errors.New("only SELECT statements are allowed")
+	}
+
+	for _, token := range tokens {
+
+		switch {
+		case token.kind == tokenSymbol && token.value == ";":
+			return errors.New("multiple statements are not allowed")
+
+		case token.kind == tokenSymbol && token.value == ":=":
+			return errors.New("variable assignment is not allowed")
+
+		case token.kind == tokenWord && forbiddenSQLWords[strings.ToUpper(token.value)]:
+			return fmt.Errorf("%s is not allowed", strings.ToUpper(token.value))
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,44 @@
+package dbexplorer
+
+import "testing"
+
+func TestCheckReadOnlySQL(t *testing.T) {
+
+	allowed := []string{
+		"SELECT * FROM items",
+		"select id, title from items where title = 'DROP TABLE items; --' limit 5;",
+		"WITH t AS (SELECT 1 AS x) SELECT x FROM t",
+		"(SELECT 1) UNION (SELECT 2)",
+		"SELECT `update`, \"it's\" FROM items -- trailing; comment",
+		"SELECT /* insert */ 1 # delete",
+		`SELECT 'it\'s; fine'`,
+	}
+
+	for _, query := range allowed {
+		if err := checkReadOnlySQL(query); err != nil {
+			t.Errorf("%q rejected: %v", query, err)
+		}
+	}
+
+	rejected := []string{
+		"",
+		"  ;",
+		"DELETE FROM items",
+		"SELECT 1; DROP TABLE items",
+		"SELECT * FROM items INTO OUTFILE '/tmp/x'",
+		"SELECT * FROM items FOR UPDATE",
+		"SELECT * FROM items LOCK IN SHARE MODE",
+		"SELECT @a := 1",
+		"SELECT /*!50000 SLEEP(10) */ 1",
+		"SELECT LOAD_FILE('/etc/passwd')",
+		"SELECT 'unterminated",
+		"SHOW TABLES",
+		"SELECT 1 --\nDROP TABLE items",
+	}
+
+	for _, query := range rejected {
+		if err := checkReadOnlySQL(query); err == nil {
+			t.Errorf("%q accepted", query)
+		}
+	}
+}
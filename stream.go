@@ -0,0 +1,297 @@
+package dbexplorer
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Форматы потоковой выдачи записей
+const (
+	FormatJSON   = "json"
+	FormatNDJSON = "ndjson"
+	FormatCSV    = "csv"
+)
+
+// Тип содержимого по формату
+var formatContentTypes = map[string]string{
+	FormatJSON:   "application/json",
+	FormatNDJSON: "application/x-ndjson",
+	FormatCSV:    "text/csv; charset=utf-8; header=present",
+}
+
+// Трейлер с ошибкой, случившейся посреди CSV: в самом CSV ей негде быть
+const streamErrorTrailer = "X-Stream-Error"
+
+// Настройки выгрузки в CSV и NDJSON
+type ExportConfig struct {
+	// Как писать NULL в CSV. В DefaultConfig - \N, как у LOAD DATA: пустая строка
+	// - это значение NOT NULL столбца. Запрос может переопределить параметром ?null=
+	Null string `yaml:"null"`
+}
+
+// Формат ответа: ?format= важнее заголовка Accept. По умолчанию JSON
+func streamFormat(r *http.Request) (string, error) {
+
+	if format := r.URL.Query().Get("format"); format != "" {
+
+		if _, ok := formatContentTypes[format]; !ok {
+			return "", FieldProblem(http.StatusBadRequest, CodeInvalidParam, "format",
+				fmt.Sprintf("format must be one of %s, %s or %s", FormatJSON, FormatNDJSON, FormatCSV))
+		}
+
+		return format, nil
+	}
+
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept))
+
+		if err != nil {
+			continue
+		}
+
+		switch mediaType {
+		case "text/csv":
+			return FormatCSV, nil
+		case "application/x-ndjson", "application/ndjson":
+			return FormatNDJSON, nil
+		case "application/json":
+			return FormatJSON, nil
+		}
+	}
+
+	return FormatJSON, nil
+}
+
+// Потоковая запись записей: JSON {"response": {"records": [...]}}, NDJSON по записи
+// в строке или CSV со строкой заголовков. В памяти только текущая запись. Заголовки
+// отправляем с первой записью, поэтому ошибку до нее можно отдать обычным ответом
+type recordStream struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	format  string
+	// Порядок столбцов CSV
+	columns []string
+	null    string
+	csv     *csv.Writer
+	started bool
+	count   int
+	bytes   int
+}
+
+func newRecordStream(w http.ResponseWriter, format string, columns []string, null string) *recordStream {
+
+	flusher, _ := w.(http.Flusher)
+
+	s := &recordStream{w: w, flusher: flusher, format: format, columns: columns, null: null}
+
+	if format == FormatCSV {
+		s.csv = csv.NewWriter(streamWriter{s})
+		// RFC 4180: строки заканчиваются CRLF
+		s.csv.UseCRLF = true
+	}
+
+	return s
+}
+
+// Пишет в ответ через recordStream, чтобы считать байты
+type streamWriter struct {
+	s *recordStream
+}
+
+func (sw streamWriter) Write(p []byte) (int, error) {
+
+	n, err := sw.s.w.Write(p)
+	sw.s.bytes += n
+
+	return n, err
+}
+
+func (s *recordStream) write(p []byte) error {
+
+	_, err := streamWriter{s}.Write(p)
+
+	return err
+}
+
+func (s *recordStream) start() error {
+
+	s.w.Header().Set("Content-Type", formatContentTypes[s.format])
+	s.started = true
+
+	if s.format == FormatCSV {
+		s.w.Header().Set("Trailer", streamErrorTrailer)
+	}
+
+	s.w.WriteHeader(http.StatusOK)
+
+	switch s.format {
+	case FormatJSON:
+		return s.write([]byte(`{"response":{"records":[`))
+	case FormatCSV:
+		return s.csv.Write(s.columns)
+	}
+
+	return nil
+}
+
+// Значение для CSV
+func (s *recordStream) csvValue(value interface{}) string {
+
+	switch v := value.(type) {
+	case nil:
+		return s.null
+	case string:
+		return v
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+
+	return fmt.Sprint(value)
+}
+
+// Пишем одну запись. Ошибка - клиент не принимает ответ, продолжать нет смысла
+func (s *recordStream) Write(record map[string]interface{}) error {
+
+	var line []byte
+	var err error
+
+	if s.format != FormatCSV {
+		line, err = json.Marshal(record)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if !s.started {
+		err = s.start()
+	} else if s.format == FormatJSON {
+		err = s.write([]byte{','})
+	}
+
+	if err != nil {
+		return err
+	}
+
+	switch s.format {
+
+	case FormatCSV:
+		row := make([]string, len(s.columns))
+		for i, column := range s.columns {
+			row[i] = s.csvValue(record[column])
+		}
+		err = s.csv.Write(row)
+
+	case FormatNDJSON:
+		err = s.write(append(line, '\n'))
+
+	default:
+		err = s.write(line)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	s.count++
+
+	if s.count%flushEvery == 0 {
+		return s.flush()
+	}
+
+	return nil
+}
+
+func (s *recordStream) flush() error {
+
+	if s.csv != nil {
+
+		s.csv.Flush()
+
+		if err := s.csv.Error(); err != nil {
+			return err
+		}
+	}
+
+	if s.flusher != nil {
+		s.flusher.Flush()
+	}
+
+	return nil
+}
+
+// Закрываем ответ. Если запись уже пошла, ошибка становится полем "error" после
+// "response" в JSON, последней строкой {"error": ...} в NDJSON и трейлером
+// X-Stream-Error в CSV. false - ничего не отправлено, ошибку должен ответить вызывающий
+func (s *recordStream) Close(ctx context.Context, r *http.Request, failure error) bool {
+
+	if !s.started && failure != nil {
+		return false
+	}
+
+	if !s.started {
+		s.start() //nolint:errcheck
+	}
+
+	var problem []byte
+
+	if failure != nil {
+		problem, _ = json.Marshal(streamProblem(ctx, r))
+	}
+
+	switch s.format {
+
+	case FormatCSV:
+		s.flush() //nolint:errcheck
+		if failure != nil {
+			s.w.Header().Set(streamErrorTrailer, string(problem))
+		}
+
+	case FormatNDJSON:
+		if failure != nil {
+			s.write([]byte(fmt.Sprintf("{\"error\":%s}\n", problem))) //nolint:errcheck
+		}
+
+	default:
+		s.write([]byte(`]}`)) //nolint:errcheck
+		if failure != nil {
+			s.write([]byte(fmt.Sprintf(`,"error":%s`, problem))) //nolint:errcheck
+		}
+		s.write([]byte{'}'}) //nolint:errcheck
+	}
+
+	return true
+}
+
+// Ошибка, случившаяся после отправки статуса 200
+func streamProblem(ctx context.Context, r *http.Request) *Problem {
+
+	p := NewProblem(http.StatusInternalServerError, CodeInternal, "query failed after the response was started")
+
+	if ctx.Err() == context.DeadlineExceeded {
+		p = NewProblem(http.StatusGatewayTimeout, CodeTimeout, "query timeout")
+	}
+
+	p.RequestID = RequestIDFromContext(r.Context())
+
+	return p
+}
+
+// NULL для CSV: из ?null= или из настроек
+func (h *Handler) exportNull(r *http.Request) string {
+
+	if r.URL.Query().Has("null") {
+		return r.URL.Query().Get("null")
+	}
+
+	return h.Config.Export.Null
+}
@@ -0,0 +1,153 @@
+package dbexplorer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRecordStream(t *testing.T) {
+
+	ctx := context.Background()
+	r := httptest.NewRequest("GET", "/items", nil)
+
+	w := httptest.NewRecorder()
+	stream := newRecordStream(w, FormatJSON, nil, "")
+
+	for i := 0; i < flushEvery+1; i++ {
+		if err := stream.Write(map[string]interface{}{"id": i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !w.Flushed {
+		t.Error("stream must flush every flushEvery records")
+	}
+
+	stream.Close(ctx, r, nil)
+
+	var body struct {
+		Response struct {
+			Records []map[string]int `json:"records"`
+		} `json:"response"`
+		Error *Problem `json:"error"`
+	}
+
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || len(body.Response.Records) != flushEvery+1 || body.Error != nil {
+		t.Errorf("got %v: %s", err, w.Body)
+	}
+
+	w = httptest.NewRecorder()
+
+	if !newRecordStream(w, FormatJSON, nil, "").Close(ctx, r, nil) || w.Body.String() != `{"response":{"records":[]}}` {
+		t.Errorf("empty stream: got %s", w.Body)
+	}
+
+	w = httptest.NewRecorder()
+
+	if newRecordStream(w, FormatJSON, nil, "").Close(ctx, r, errors.New("boom")) || w.Body.Len() != 0 {
+		t.Errorf("error before the first record must be left to the caller, got %s", w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	stream = newRecordStream(w, FormatJSON, nil, "")
+	stream.Write(map[string]interface{}{"id": 1}) //nolint:errcheck
+
+	if !stream.Close(ctx, r, errors.New("boom")) {
+		t.Fatal("started stream must be closed by itself")
+	}
+
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body.Error == nil || body.Error.Code != CodeInternal {
+		t.Errorf("got %v: %s", err, w.Body)
+	}
+}
+
+func TestStreamFormat(t *testing.T) {
+
+	cases := []struct {
+		query  string
+		accept string
+		format string
+		fail   bool
+	}{
+		{"", "", FormatJSON, false},
+		{"", "text/csv", FormatCSV, false},
+		{"", "application/x-ndjson;q=0.9, */*", FormatNDJSON, false},
+		{"format=ndjson", "text/csv", FormatNDJSON, false},
+		{"", "text/html, */*", FormatJSON, false},
+		{"format=xml", "", "", true},
+	}
+
+	for _, c := range cases {
+
+		r := httptest.NewRequest("GET", "/items?"+c.query, nil)
+		r.Header.Set("Accept", c.accept)
+
+		format, err := streamFormat(r)
+
+		if c.fail {
+			if p, ok := err.(*Problem); !ok || p.Field != "format" {
+				t.Errorf("%s: got %v, want problem", c.query, err)
+			}
+			continue
+		}
+
+		if err != nil || format != c.format {
+			t.Errorf("%s %s: got %s, %v, want %s", c.query, c.accept, format, err, c.format)
+		}
+	}
+}
+
+func TestRecordStreamCSV(t *testing.T) {
+
+	ctx := context.Background()
+	r := httptest.NewRequest("GET", "/items?format=csv", nil)
+
+	w := httptest.NewRecorder()
+	stream := newRecordStream(w, FormatCSV, []string{"id", "title", "note"}, `\N`)
+
+	stream.Write(map[string]interface{}{"id": int64(1), "title": `say "hi", bob`, "note": nil}) //nolint:errcheck
+	stream.Write(map[string]interface{}{"id": int64(2), "title": "two\nlines", "note": "x"})    //nolint:errcheck
+	stream.Close(ctx, r, errors.New("boom"))
+
+	// encoding/csv с UseCRLF переводит и переводы строк внутри значений в CRLF
+	want := "id,title,note\r\n1,\"say \"\"hi\"\", bob\",\\N\r\n2,\"two\r\nlines\",x\r\n"
+
+	if w.Body.String() != want {
+		t.Errorf("got %q, want %q", w.Body, want)
+	}
+
+	if w.Header().Get("Content-Type") != "text/csv; charset=utf-8; header=present" {
+		t.Errorf("got content type %s", w.Header().Get("Content-Type"))
+	}
+
+	if trailer := w.Result().Trailer.Get(streamErrorTrailer); trailer == "" {
+		t.Error("error after the first row must go to the trailer")
+	}
+
+	w = httptest.NewRecorder()
+	newRecordStream(w, FormatCSV, []string{"id"}, "").Close(ctx, r, nil)
+
+	if w.Body.String() != "id\r\n" {
+		t.Errorf("empty csv: got %q", w.Body)
+	}
+}
+
+func TestRecordStreamNDJSON(t *testing.T) {
+
+	w := httptest.NewRecorder()
+	stream := newRecordStream(w, FormatNDJSON, nil, "")
+
+	stream.Write(map[string]interface{}{"id": 1}) //nolint:errcheck
+	stream.Write(map[string]interface{}{"id": 2}) //nolint:errcheck
+	stream.Close(context.Background(), httptest.NewRequest("GET", "/items", nil), errors.New("boom"))
+
+	lines := strings.Split(strings.TrimSuffix(w.Body.String(), "\n"), "\n")
+
+	if len(lines) != 3 || lines[0] != `{"id":1}` || !strings.HasPrefix(lines[2], `{"error":{`) {
+		t.Errorf("got %q", w.Body)
+	}
+}
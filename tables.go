@@ -0,0 +1,225 @@
+package dbexplorer
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Какие таблицы показывать через API и под какими именами
+type TablesConfig struct {
+	// Шаблоны имен таблиц в БД: glob (users_*) или регулярное выражение
+	// в слешах (/^tmp_/). Пусто - все таблицы
+	Include []string `yaml:"include"`
+	// Шаблоны таблиц, которые скрываем, даже если они попали в Include
+	Exclude []string `yaml:"exclude"`
+	// Публичные имена по имени таблицы в БД
+	Aliases map[string]TableAlias `yaml:"aliases"`
+	// Столбец, по которому читаем запись представления по id, по имени представления в БД.
+	// Без него запись представления по id не прочитать
+	ViewKeys map[string]string `yaml:"view_keys"`
+}
+
+// Публичное имя таблицы и ее столбцов
+type TableAlias struct {
+	// Имя таблицы в URL. Пусто - как в БД
+	Name string `yaml:"name"`
+	// Имена столбцов в JSON по имени столбца в БД
+	Columns map[string]string `yaml:"columns"`
+}
+
+// Имя таблицы в БД. Name - публичное имя, оно может быть алиасом
+func (t TableInfo) Table() string {
+
+	if t.DBName != "" {
+		return t.DBName
+	}
+
+	return t.Name
+}
+
+// Имя столбца в БД. Name - публичное имя, оно может быть алиасом
+func (f FieldInfo) Column() string {
+
+	if f.DBName != "" {
+		return f.DBName
+	}
+
+	return f.Name
+}
+
+// Ищем столбец по имени в БД
+func fieldByColumn(table TableInfo, column string) (FieldInfo, bool) {
+
+	for _, field := range table.Fields {
+		if field.Column() == column {
+			return field, true
+		}
+	}
+
+	return FieldInfo{}, false
+}
+
+// Пути служебных эндпоинтов вне "/_...". Таблица под таким именем была бы недоступна
+var reservedNames = map[string]bool{
+	"metrics": true,
+	"healthz": true,
+	"readyz":  true,
+}
+
+// Проверяем алиасы при загрузке настроек: имя не должно совпасть со служебным
+// эндпоинтом, начинаться с "_" (там /_sql, /_rpc и другие) или повторять другой алиас
+func (c TablesConfig) validate() error {
+
+	names := make(map[string]string, len(c.Aliases))
+
+	for table, alias := range c.Aliases {
+
+		if alias.Name == "" {
+			continue
+		}
+
+		if reservedNames[alias.Name] || strings.HasPrefix(alias.Name, "_") {
+			return fmt.Errorf("alias %s of table %s is a reserved route name", alias.Name, table)
+		}
+
+		if strings.Contains(alias.Name, "/") {
+			return fmt.Errorf("alias %s of table %s must not contain /", alias.Name, table)
+		}
+
+		if other, ok := names[alias.Name]; ok {
+			return fmt.Errorf("tables %s and %s have the same alias %s", other, table, alias.Name)
+		}
+
+		names[alias.Name] = table
+	}
+
+	return nil
+}
+
+// Шаблон имени таблицы
+type namePattern func(name string) bool
+
+func compilePatterns(patterns []string) ([]namePattern, error) {
+
+	compiled := make([]namePattern, 0, len(patterns))
+
+	for _, pattern := range patterns {
+
+		if len(pattern) > 1 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+
+			re, err := regexp.Compile(pattern[1 : len(pattern)-1])
+
+			if err != nil {
+				return nil, fmt.Errorf("bad table pattern %q: %v", pattern, err)
+			}
+
+			compiled = append(compiled, re.MatchString)
+			continue
+		}
+
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("bad table pattern %q: %v", pattern, err)
+		}
+
+		glob := pattern
+
+		compiled = append(compiled, func(name string) bool {
+			ok, _ := path.Match(glob, name)
+			return ok
+		})
+	}
+
+	return compiled, nil
+}
+
+func matchAny(patterns []namePattern, name string) bool {
+
+	for _, match := range patterns {
+		if match(name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Оставляем разрешенные таблицы и проставляем публичные имена таблицам и столбцам
+func (c TablesConfig) apply(tables []TableInfo) ([]TableInfo, error) {
+
+	include, err := compilePatterns(c.Include)
+
+	if err != nil {
+		return nil, err
+	}
+
+	exclude, err := compilePatterns(c.Exclude)
+
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]TableInfo, 0, len(tables))
+	names := make(map[string]string, len(tables))
+
+	for _, table := range tables {
+
+		if len(include) > 0 && !matchAny(include, table.Name) || matchAny(exclude, table.Name) {
+			continue
+		}
+
+		alias := c.Aliases[table.Name]
+
+		table.DBName = table.Name
+
+		if alias.Name != "" {
+			table.Name = alias.Name
+		}
+
+		// алиасы проверены в validate, а имя без алиаса берем из БД как есть
+		if reservedNames[table.Name] || strings.HasPrefix(table.Name, "_") {
+			return nil, fmt.Errorf("table %s is shadowed by a service route, exclude it or set an alias", table.DBName)
+		}
+
+		if other, ok := names[table.Name]; ok {
+			return nil, fmt.Errorf("tables %s and %s have the same public name %s", other, table.DBName, table.Name)
+		}
+
+		names[table.Name] = table.DBName
+
+		fields := make([]FieldInfo, len(table.Fields))
+		columns := make(map[string]bool, len(table.Fields))
+
+		for i, field := range table.Fields {
+
+			field.DBName = field.Name
+
+			if name := alias.Columns[field.Name]; name != "" {
+				field.Name = name
+			}
+
+			if columns[field.Name] {
+				return nil, fmt.Errorf("table %s has two columns with public name %s", table.DBName, field.Name)
+			}
+
+			columns[field.Name] = true
+			fields[i] = field
+		}
+
+		table.Fields = fields
+
+		if key, ok := c.ViewKeys[table.DBName]; ok && table.View {
+
+			if _, ok := fieldByColumn(table, key); !ok {
+				return nil, fmt.Errorf("view %s has no key column %s", table.DBName, key)
+			}
+
+			table.ID = key
+		}
+
+		result = append(result, table)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,107 @@
+package dbexplorer
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTablesConfigApply(t *testing.T) {
+
+	tables := []TableInfo{
+		{Name: "items", ID: "id", Fields: []FieldInfo{{Name: "id", IsKey: true}, {Name: "title"}}},
+		{Name: "tbl_usr", ID: "usr_id", Fields: []FieldInfo{{Name: "usr_id", IsKey: true}, {Name: "usr_nm"}}},
+		{Name: "schema_migrations", ID: "version"},
+		{Name: "tmp_import", ID: "id"},
+		{Name: "sessions", ID: "id"},
+	}
+
+	cfg := TablesConfig{
+		Exclude: []string{"schema_migrations", "/^tmp_/", "sess*"},
+		Aliases: map[string]TableAlias{
+			"tbl_usr": {Name: "people", Columns: map[string]string{"usr_nm": "name"}},
+		},
+	}
+
+	got, err := cfg.apply(tables)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []TableInfo{
+		{Name: "items", DBName: "items", ID: "id", Fields: []FieldInfo{
+			{Name: "id", DBName: "id", IsKey: true}, {Name: "title", DBName: "title"}}},
+		{Name: "people", DBName: "tbl_usr", ID: "usr_id", Fields: []FieldInfo{
+			{Name: "usr_id", DBName: "usr_id", IsKey: true}, {Name: "name", DBName: "usr_nm"}}},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+
+	if columns := GetColumnsTable(got[1], false); columns != "usr_id,usr_nm" {
+		t.Errorf("got columns %q", columns)
+	}
+
+	got, err = TablesConfig{Include: []string{"/^(items|tbl_.*)$/"}}.apply(tables)
+
+	if err != nil || len(got) != 2 || got[1].Name != "tbl_usr" {
+		t.Errorf("include: got %+v, %v", got, err)
+	}
+
+	views := []TableInfo{{Name: "active_users", View: true, Fields: []FieldInfo{{Name: "user_id"}, {Name: "login"}}}}
+
+	got, err = TablesConfig{ViewKeys: map[string]string{"active_users": "user_id"}}.apply(views)
+
+	if err != nil || got[0].ID != "user_id" {
+		t.Errorf("view key: got %+v, %v", got, err)
+	}
+
+	if _, err = (TablesConfig{ViewKeys: map[string]string{"active_users": "nope"}}).apply(views); err == nil {
+		t.Error("missing view key column accepted")
+	}
+
+	if _, err = (TablesConfig{Exclude: []string{"/(/"}}).apply(tables); err == nil {
+		t.Error("bad regexp accepted")
+	}
+
+	if _, err = (TablesConfig{Aliases: map[string]TableAlias{"tbl_usr": {Name: "items"}}}).apply(tables); err == nil {
+		t.Error("duplicate public table name accepted")
+	}
+
+	for _, name := range []string{"metrics", "_drafts"} {
+
+		shadowed := append(tables[:2:2], TableInfo{Name: name, ID: "id"})
+
+		if _, err = (TablesConfig{}).apply(shadowed); err == nil {
+			t.Errorf("table %s shadowed by a service route accepted", name)
+		}
+
+		aliased := TablesConfig{Aliases: map[string]TableAlias{name: {Name: "drafts"}}}
+
+		if _, err = aliased.apply(shadowed); err != nil {
+			t.Errorf("aliased table %s: %v", name, err)
+		}
+	}
+}
+
+func TestTablesConfigValidate(t *testing.T) {
+
+	cases := []struct {
+		aliases map[string]TableAlias
+		ok      bool
+	}{
+		{map[string]TableAlias{"tbl_usr": {Name: "people"}, "tbl_ord": {Columns: map[string]string{"a": "b"}}}, true},
+		{map[string]TableAlias{"tbl_usr": {Name: "metrics"}}, false},
+		{map[string]TableAlias{"tbl_usr": {Name: "healthz"}}, false},
+		{map[string]TableAlias{"tbl_usr": {Name: "_sql"}}, false},
+		{map[string]TableAlias{"tbl_usr": {Name: "a/b"}}, false},
+		{map[string]TableAlias{"tbl_usr": {Name: "people"}, "tbl_ppl": {Name: "people"}}, false},
+	}
+
+	for i, c := range cases {
+		if err := (TablesConfig{Aliases: c.aliases}).validate(); (err == nil) != c.ok {
+			t.Errorf("case %d: got %v, want ok=%v", i, err, c.ok)
+		}
+	}
+}
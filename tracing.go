@@ -0,0 +1,290 @@
+package dbexplorer
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	TracingExporterNone   = "none"
+	TracingExporterStdout = "stdout"
+)
+
+// Настройки трассировки
+type TracingConfig struct {
+	// Куда отправляем спаны: "none" или "stdout" (JSON lines)
+	Exporter string `yaml:"exporter"`
+	// Свой экспортер. Если задан, Exporter не используется
+	SpanExporter SpanExporter `yaml:"-"`
+}
+
+// Отрезок работы внутри запроса: HTTP-запрос целиком, SQL-запрос, кодирование JSON
+type Span struct {
+	TraceID    string                 `json:"trace_id"`
+	SpanID     string                 `json:"span_id"`
+	ParentID   string                 `json:"parent_id,omitempty"`
+	Name       string                 `json:"name"`
+	Start      time.Time              `json:"start"`
+	End        time.Time              `json:"end"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+	Error      string                 `json:"error,omitempty"`
+
+	sampled  bool
+	exporter SpanExporter
+	mu       sync.Mutex
+}
+
+// Получатель завершенных спанов
+type SpanExporter interface {
+	ExportSpan(span *Span) error
+}
+
+// Пишем спаны JSON lines в поток
+type JSONSpanExporter struct {
+	Out io.Writer
+	mu  sync.Mutex
+}
+
+func (e *JSONSpanExporter) ExportSpan(span *Span) error {
+
+	span.mu.Lock()
+	line, err := json.Marshal(span)
+	span.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	_, err = e.Out.Write(append(line, '\n'))
+
+	return err
+}
+
+// Создаем экспортер по настройкам. nil - трассировка выключена
+func NewSpanExporter(cfg TracingConfig) (SpanExporter, error) {
+
+	if cfg.SpanExporter != nil {
+		return cfg.SpanExporter, nil
+	}
+
+	switch cfg.Exporter {
+	case TracingExporterNone:
+		return nil, nil
+	case TracingExporterStdout:
+		return &JSONSpanExporter{Out: os.Stdout}, nil
+	}
+
+	return nil, fmt.Errorf("unknown tracing exporter %q", cfg.Exporter)
+}
+
+func randomHex(n int) string {
+
+	b := make([]byte, n)
+
+	if _, err := rand.Read(b); err != nil {
+		return strings.Repeat("0", 2*n-1) + "1"
+	}
+
+	return hex.EncodeToString(b)
+}
+
+func isHex(s string, n int) bool {
+
+	if len(s) != n || strings.Trim(s, "0") == "" {
+		return false
+	}
+
+	_, err := hex.DecodeString(s)
+
+	return err == nil
+}
+
+// Разбираем W3C traceparent: 00-<trace-id>-<parent-id>-<flags>
+func parseTraceparent(header string) (traceID, parentID string, sampled, ok bool) {
+
+	parts := strings.Split(strings.TrimSpace(header), "-")
+
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return "", "", false, false
+	}
+
+	if !isHex(parts[1], 32) || !isHex(parts[2], 16) || len(parts[3]) != 2 {
+		return "", "", false, false
+	}
+
+	flags, err := hex.DecodeString(parts[3])
+
+	if err != nil {
+		return "", "", false, false
+	}
+
+	return parts[1], parts[2], flags[0]&1 == 1, true
+}
+
+type spanKey struct{}
+
+// Текущий спан из контекста
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// Начинаем дочерний спан текущего спана. Без экспортера возвращаем nil,
+// все методы Span к nil безопасны
+func startSpan(ctx context.Context, name string) (context.Context, *Span) {
+
+	parent := SpanFromContext(ctx)
+
+	if parent == nil {
+		return ctx, nil
+	}
+
+	span := &Span{
+		TraceID:  parent.TraceID,
+		SpanID:   randomHex(8),
+		ParentID: parent.SpanID,
+		Name:     name,
+		Start:    time.Now(),
+		sampled:  parent.sampled,
+		exporter: parent.exporter,
+	}
+
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+func (s *Span) SetAttr(key string, value interface{}) {
+
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.Attributes == nil {
+		s.Attributes = make(map[string]interface{})
+	}
+
+	s.Attributes[key] = value
+}
+
+// Прибавляем n к числовому атрибуту
+func (s *Span) AddAttr(key string, n int64) {
+
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.Attributes == nil {
+		s.Attributes = make(map[string]interface{})
+	}
+
+	current, _ := s.Attributes[key].(int64)
+	s.Attributes[key] = current + n
+}
+
+func (s *Span) SetError(err error) {
+
+	if s == nil || err == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Error = err.Error()
+}
+
+// Завершаем спан и отдаем его экспортеру
+func (s *Span) Finish() {
+
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	s.End = time.Now()
+	s.mu.Unlock()
+
+	if !s.sampled {
+		return
+	}
+
+	// ошибки экспорта не должны ломать запрос
+	_ = s.exporter.ExportSpan(s)
+}
+
+// Заголовок traceparent для этого спана
+func (s *Span) Traceparent() string {
+
+	flags := "00"
+
+	if s.sampled {
+		flags = "01"
+	}
+
+	return fmt.Sprintf("00-%s-%s-%s", s.TraceID, s.SpanID, flags)
+}
+
+// Открываем корневой спан на каждый HTTP-запрос. Если клиент прислал traceparent,
+// продолжаем его трассу
+func (h *Handler) trace(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if h.SpanExporter == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		span := &Span{
+			TraceID:  randomHex(16),
+			SpanID:   randomHex(8),
+			Name:     "HTTP " + r.Method,
+			Start:    time.Now(),
+			sampled:  true,
+			exporter: h.SpanExporter,
+		}
+
+		if traceID, parentID, sampled, ok := parseTraceparent(r.Header.Get("traceparent")); ok {
+			span.TraceID, span.ParentID, span.sampled = traceID, parentID, sampled
+		}
+
+		table, operation := h.requestLabels(r)
+
+		span.SetAttr("http.method", r.Method)
+		span.SetAttr("http.target", r.URL.Path)
+		span.SetAttr("db.table", table)
+		span.SetAttr("explorer.operation", operation)
+
+		w.Header().Set("traceparent", span.Traceparent())
+
+		ctx := context.WithValue(r.Context(), spanKey{}, span)
+		ctx = context.WithValue(ctx, loggerKey, loggerFrom(ctx).With("trace_id", span.TraceID))
+
+		rec := &statusRecorder{ResponseWriter: w}
+
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		span.SetAttr("http.status_code", rec.status)
+		span.Finish()
+	})
+}
@@ -0,0 +1,94 @@
+package dbexplorer
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTraceSpans(t *testing.T) {
+
+	out := &bytes.Buffer{}
+
+	h := &Handler{
+		SpanExporter: &JSONSpanExporter{Out: out},
+	}
+
+	h.schema.Store(&Schema{Tables: []TableInfo{{Name: "items", ID: "id"}}})
+
+	handler := h.trace(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, span := h.startQuerySpan(r.Context(), "items", "list", "SELECT 1")
+		span.Finish()
+		h.rowsReturned(r.Context(), "items", "list", 2)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/items", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	spans := make([]*Span, 0)
+
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+
+		span := &Span{}
+
+		if err := json.Unmarshal([]byte(line), span); err != nil {
+			t.Fatal(err)
+		}
+
+		spans = append(spans, span)
+	}
+
+	if len(spans) != 2 {
+		t.Fatalf("expected sql and http spans, got %s", out.String())
+	}
+
+	sqlSpan, httpSpan := spans[0], spans[1]
+
+	if httpSpan.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || httpSpan.ParentID != "00f067aa0ba902b7" {
+		t.Errorf("incoming traceparent not continued: %+v", httpSpan)
+	}
+
+	if sqlSpan.ParentID != httpSpan.SpanID || sqlSpan.Attributes["db.table"] != "items" {
+		t.Errorf("bad sql span: %+v", sqlSpan)
+	}
+
+	if httpSpan.Attributes["db.rows_returned"] != float64(2) || httpSpan.Attributes["http.status_code"] != float64(200) {
+		t.Errorf("bad http span attributes: %+v", httpSpan.Attributes)
+	}
+
+	expected := "00-4bf92f3577b34da6a3ce929d0e0e4736-" + httpSpan.SpanID + "-01"
+
+	if got := rec.Header().Get("traceparent"); got != expected {
+		t.Errorf("traceparent: got %q, want %q", got, expected)
+	}
+}
+
+func TestParseTraceparent(t *testing.T) {
+
+	bad := []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473z-00f067aa0ba902b7-01",
+	}
+
+	for _, header := range bad {
+		if _, _, _, ok := parseTraceparent(header); ok {
+			t.Errorf("%q must be rejected", header)
+		}
+	}
+
+	_, _, sampled, ok := parseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+
+	if !ok || sampled {
+		t.Error("unsampled traceparent must be parsed")
+	}
+}